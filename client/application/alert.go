@@ -0,0 +1,214 @@
+package application
+
+import (
+	"amah/ring"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type AlertAction string
+
+const (
+	AlertActionNone AlertAction = ""
+	// AlertActionRestart is done by the Listener, as a Client only lives as long as one run.
+	AlertActionRestart    AlertAction = "restart"
+	AlertActionThreadDump AlertAction = "threadDump" // send SIGQUIT, which makes a JVM print stack traces.
+	// AlertActionWebhook is done by the Listener too, through the notifier with its retry and signature.
+	AlertActionWebhook AlertAction = "webhook"
+)
+
+const DefaultAlertCooldown = time.Minute
+
+// alertContextWait is how long to wait for lines after the match before raise anyway.
+const alertContextWait = 2 * time.Second
+
+// AlertRule is evaluated on every output line.
+type AlertRule struct {
+	Name    string
+	Pattern string
+	Stream  string // "stdout" or "stderr", empty means both.
+	// Threshold makes it alert only if more than Threshold lines matched in Window, zero means on every match.
+	Threshold int
+	Window    time.Duration
+	Context   int         // how many lines before and after the match to carry
+	Action    AlertAction // what to do other than record an EventAlert
+	Webhook   string      // where to POST the EventAlert as JSON on AlertActionWebhook
+	// Cooldown is the min interval between two alerts of the rule, zero means DefaultAlertCooldown.
+	Cooldown time.Duration
+}
+
+type Alert struct {
+	Rule       string
+	Action     AlertAction `json:",omitempty"`
+	Line       string      // the one triggers
+	Count      int         // matched lines in the Window, or 1 if no Threshold
+	Suppressed int         `json:",omitempty"` // matched lines ignored in cooldown since last alert
+	Context    []string    `json:",omitempty"` // lines around, Line included
+	Webhook    string      `json:"-"`          // of the rule, which may carry a secret in query so never shown
+}
+
+type ruleState struct {
+	AlertRule
+	pattern    *regexp.Regexp
+	matchedAt  []time.Time // in Window, only used with Threshold
+	lastAlert  time.Time
+	suppressed int
+}
+
+type pendingAlert struct {
+	alert    Alert
+	after    int // lines left to collect after
+	deadline time.Time
+}
+
+// alerter evaluates AlertRule on lines, it's not thread-safe and shall only be used in tee.
+type alerter struct {
+	rules   []*ruleState
+	recent  ring.Ring[string] // for context before
+	pending []*pendingAlert
+}
+
+func newAlerter(rules []AlertRule) (*alerter, error) {
+	ret := &alerter{}
+	maxContext := 0
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern on alert rule %s: %w", rule.Name, err)
+		}
+		if rule.Threshold > 0 && rule.Window <= 0 {
+			return nil, fmt.Errorf("no window on alert rule %s with threshold", rule.Name)
+		}
+		if rule.Cooldown <= 0 {
+			rule.Cooldown = DefaultAlertCooldown
+		}
+		maxContext = max(maxContext, rule.Context)
+		ret.rules = append(ret.rules, &ruleState{AlertRule: rule, pattern: pattern})
+	}
+	// Capacity shall be positive, and one more for the line itself.
+	ret.recent = ring.New[string](maxContext + 1)
+	return ret, nil
+}
+
+func (a *alerter) empty() bool {
+	return len(a.rules) == 0
+}
+
+// watch evaluates line on rules, and returns those alerts ready to raise.
+func (a *alerter) watch(line string, now time.Time) []Alert {
+	if a.empty() {
+		return nil
+	}
+	a.recent.Add(line)
+	ret := a.collect(line, now)
+
+	if strings.HasPrefix(line, ">") {
+		return ret
+	}
+	stream, content := "stdout", line
+	if s, found := strings.CutPrefix(line, "!"); found {
+		stream, content = "stderr", s
+	}
+	for _, rule := range a.rules {
+		if rule.Stream != "" && rule.Stream != stream {
+			continue
+		}
+		if !rule.pattern.MatchString(content) {
+			continue
+		}
+		count := 1
+		if rule.Threshold > 0 {
+			rule.matchedAt = append(rule.matchedAt, now)
+			from := 0
+			for from < len(rule.matchedAt) && now.Sub(rule.matchedAt[from]) > rule.Window {
+				from++
+			}
+			rule.matchedAt = rule.matchedAt[from:]
+			count = len(rule.matchedAt)
+			if count <= rule.Threshold {
+				continue
+			}
+		}
+		if now.Sub(rule.lastAlert) < rule.Cooldown {
+			rule.suppressed++
+			continue
+		}
+		rule.lastAlert = now
+		alert := Alert{
+			Rule:       rule.Name,
+			Action:     rule.Action,
+			Line:       line,
+			Count:      count,
+			Suppressed: rule.suppressed,
+			Context:    nil,
+			Webhook:    rule.Webhook,
+		}
+		rule.suppressed = 0
+		if rule.Context == 0 {
+			ret = append(ret, alert)
+			continue
+		}
+		alert.Context = a.before(rule.Context)
+		a.pending = append(a.pending, &pendingAlert{
+			alert:    alert,
+			after:    rule.Context,
+			deadline: now.Add(alertContextWait),
+		})
+	}
+	return ret
+}
+
+// before returns the last n lines before the current one, and the current one.
+func (a *alerter) before(n int) []string {
+	lines := a.recent.Get()
+	return lines[max(len(lines)-n-1, 0):]
+}
+
+// collect appends line to pending alerts as context after, and returns those completed.
+func (a *alerter) collect(line string, now time.Time) []Alert {
+	var ret []Alert
+	rest := a.pending[:0]
+	for _, p := range a.pending {
+		p.alert.Context = append(p.alert.Context, line)
+		p.after--
+		if p.after <= 0 {
+			ret = append(ret, p.alert)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	a.pending = rest
+	return append(ret, a.flush(now)...)
+}
+
+// flush returns those pending alerts that can not wait for more context.
+func (a *alerter) flush(now time.Time) []Alert {
+	var ret []Alert
+	rest := a.pending[:0]
+	for _, p := range a.pending {
+		if now.After(p.deadline) {
+			ret = append(ret, p.alert)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	a.pending = rest
+	return ret
+}
+
+// raise records the alert and takes its action, actions run in background so that tee never blocks.
+func (c *Client) raise(alert Alert) {
+	slog.Warn("alert", "appID", c.appID, "rule", alert.Rule, "line", alert.Line)
+	c.events.addAlert(alert)
+
+	if alert.Action == AlertActionThreadDump {
+		go func() {
+			if err := c.signalQuit(); err != nil {
+				slog.Warn("alert thread dump", "appID", c.appID, "rule", alert.Rule, "err", err)
+			}
+		}()
+	}
+}
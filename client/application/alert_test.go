@@ -0,0 +1,69 @@
+package application
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_alerter(t *testing.T) {
+	type step struct {
+		line  string
+		after time.Duration // since the beginning
+		want  []Alert
+	}
+	tests := []struct {
+		name  string
+		rules []AlertRule
+		steps []step
+	}{
+		{"every match on stderr", []AlertRule{{Name: "oom", Pattern: "OutOfMemoryError", Stream: "stderr"}}, []step{
+			{"OutOfMemoryError", 0, nil},
+			{"!OutOfMemoryError", 0, []Alert{{Rule: "oom", Line: "!OutOfMemoryError", Count: 1}}},
+		}},
+		{"cooldown", []AlertRule{{Name: "e", Pattern: "E", Cooldown: time.Minute}}, []step{
+			{"E1", 0, []Alert{{Rule: "e", Line: "E1", Count: 1}}},
+			{"E2", time.Second, nil},
+			{"E3", 2 * time.Second, nil},
+			{"E4", time.Minute + time.Second, []Alert{{Rule: "e", Line: "E4", Count: 1, Suppressed: 2}}},
+		}},
+		{"threshold", []AlertRule{{Name: "e", Pattern: "ERROR", Threshold: 2, Window: time.Minute}}, []step{
+			{"ERROR 1", 0, nil},
+			{"ERROR 2", time.Second, nil},
+			{"ERROR 3", 2 * time.Second, []Alert{{Rule: "e", Line: "ERROR 3", Count: 3}}},
+		}},
+		{"threshold out of window", []AlertRule{{Name: "e", Pattern: "ERROR", Threshold: 2, Window: time.Minute}}, []step{
+			{"ERROR 1", 0, nil},
+			{"ERROR 2", time.Second, nil},
+			{"ERROR 3", 2 * time.Minute, nil},
+		}},
+		{"context", []AlertRule{{Name: "e", Pattern: "E", Context: 1}}, []step{
+			{"a", 0, nil},
+			{"E", 0, nil},
+			{"b", 0, []Alert{{Rule: "e", Line: "E", Count: 1, Context: []string{"a", "E", "b"}}}},
+			{"c", 0, nil},
+		}},
+		{"context flush", []AlertRule{{Name: "e", Pattern: "E", Context: 2}}, []step{
+			{"E", 0, nil},
+			{"a", time.Second, nil},
+			{"b", time.Minute, []Alert{{Rule: "e", Line: "E", Count: 1, Context: []string{"E", "a", "b"}}}},
+		}},
+		{"input ignored", []AlertRule{{Name: "e", Pattern: "E"}}, []step{
+			{">[alice]E", 0, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAlerter(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			begin := time.Now()
+			for _, s := range tt.steps {
+				if got := a.watch(s.line, begin.Add(s.after)); !reflect.DeepEqual(got, s.want) {
+					t.Errorf("on %s, watch() got = %+v, want %+v", s.line, got, s.want)
+				}
+			}
+		})
+	}
+}
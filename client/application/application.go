@@ -4,12 +4,18 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 )
 
 type Application struct {
 	ID   int
 	Name string
 	Exec Exec
+	// ReadyPattern is the regexp on output line that tells the app is ready, empty means ready once started.
+	ReadyPattern string `yaml:"readyPattern" json:",omitempty"`
+	// ReadyTimeout is how long to wait for ReadyPattern, after which it's treated as ready. Zero means DefaultReadyTimeout.
+	ReadyTimeout time.Duration `yaml:"readyTimeout" json:",omitempty"`
+	Alerts       []AlertRule   `json:",omitempty"`
 }
 
 type Exec struct {
@@ -17,6 +23,12 @@ type Exec struct {
 	Path             string
 	Args             []string
 	RedirectPath     string `yaml:"redirectPath"`
+	Stdin            bool   // whether to keep stdin open for input, or the app runs with a null device.
+	TTY              bool   `yaml:"tty"` // whether to run in a pseudo-terminal, which implies Stdin.
+	// MaxLineLength is the max bytes of an output line, longer ones are truncated. Zero means DefaultMaxLineLength.
+	MaxLineLength int `yaml:"maxLineLength"`
+	// LogFormat is how to parse output lines to Record, empty is the same as LogFormatPlain.
+	LogFormat LogFormat `yaml:"logFormat"`
 }
 
 func (a Application) AbsolutePath() string {
@@ -2,35 +2,75 @@ package application
 
 import (
 	"amah/ring"
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+var ErrNoStdin = errors.New("stdin not enabled")
+var ErrTerminated = errors.New("client terminated")
+var ErrMultilineInput = errors.New("input line with newline")
+
 type Client struct {
-	appID  int
-	buf    ring.Ring[string]
-	query  chan chan []string
-	cancel context.CancelFunc
+	appID     int
+	buf       ring.Ring[Record]
+	format    LogFormat
+	query     chan chan []Record
+	lines     chan string
+	outputs   []io.Closer    // read ends of the app output, closed on Terminate for readers blocked on them
+	stdin     io.WriteCloser // nil if not Exec.Stdin
+	stdinMu   sync.Mutex     // guard stdin writes so that lines from concurrent Input never interleave, and cancel
+	ctx       context.Context
+	cancel    context.CancelFunc
+	term      *terminal // nil if not Exec.TTY
+	events    *eventHistory
+	readiness *readiness
+	alerter   *alerter
+	process   *os.Process
 }
 
-func NewClient(app Application, outputHistoryLength int) (*Client, error) {
+// NewClient starts the app and returns a Client to manage it, listener is notified on its events if not nil.
+func NewClient(app Application, outputHistoryLength int, listener Listener) (*Client, error) {
+	r, err := newReadiness(app)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newAlerter(app.Alerts)
+	if err != nil {
+		return nil, err
+	}
 	ret := &Client{
-		appID:  app.ID,
-		buf:    ring.New[string](outputHistoryLength),
-		query:  make(chan chan []string),
-		cancel: nil,
+		appID:     app.ID,
+		buf:       ring.New[Record](outputHistoryLength),
+		format:    app.Exec.LogFormat,
+		query:     make(chan chan []Record),
+		lines:     make(chan string),
+		outputs:   nil,
+		stdin:     nil,
+		stdinMu:   sync.Mutex{},
+		ctx:       nil,
+		cancel:    nil,
+		term:      nil,
+		events:    newEventHistory(app.ID, 100, listener),
+		readiness: r,
+		alerter:   a,
+		process:   nil,
 	}
 	return ret, ret.start(app)
 }
 
-// tee pipes lines in ch to wc, and save it in Ring.
+// tee pipes lines in ch to wc, and save it in Ring as Record parsed on LogFormat.
 // While piping, also make it ready for query on data stored in Ring.
+// It also watches lines for the ready pattern, until it matches or timeout.
 // In the end, would close wc. Runs forever until ctx is Done.
 func (c *Client) tee(ctx context.Context, ch <-chan string, wc io.WriteCloser) {
 	defer func(c io.Closer) {
@@ -40,10 +80,32 @@ func (c *Client) tee(ctx context.Context, ch <-chan string, wc io.WriteCloser) {
 		}
 	}(wc)
 
+	var readyTimeout <-chan time.Time
+	if c.readiness.status() == StatusStarting {
+		timer := time.NewTimer(c.readiness.timeout)
+		defer timer.Stop()
+		readyTimeout = timer.C
+	}
+	// Pending alerts wait for more lines as context, but shall not wait forever if the app goes quiet.
+	var alertFlush <-chan time.Time
+	if !c.alerter.empty() {
+		ticker := time.NewTicker(alertContextWait / 2)
+		defer ticker.Stop()
+		alertFlush = ticker.C
+	}
+
 	for {
 		select {
 		case line := <-ch:
-			c.buf.Add(line)
+			if c.readiness.watch(line) {
+				close(c.readiness.ready)
+				readyTimeout = nil
+				c.events.add(EventReady, line)
+			}
+			c.buf.Add(parseRecord(line, c.format))
+			for _, alert := range c.alerter.watch(line, time.Now()) {
+				c.raise(alert)
+			}
 			if _, err := wc.Write([]byte(line + "\n")); err != nil {
 				slog.Error("tee output drop", "appID", c.appID, "err", err, "line", line)
 				log.Fatal(err) // Eager here as I'm not sure whether running without tee piping is acceptable.
@@ -54,13 +116,21 @@ func (c *Client) tee(ctx context.Context, ch <-chan string, wc io.WriteCloser) {
 			// I don't have to close it, just confirm it's a one-shot round-trip,
 			// prevent it from waiting for more response forever.
 			close(resp)
+		case <-readyTimeout:
+			close(c.readiness.ready)
+			readyTimeout = nil
+			c.events.add(EventReadyTimeout, fmt.Sprintf("no match on %v in %v", c.readiness.pattern, c.readiness.timeout))
+		case now := <-alertFlush:
+			for _, alert := range c.alerter.flush(now) {
+				c.raise(alert)
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (c *Client) start(a Application) error {
+func (c *Client) start(a Application) (err error) {
 	if c.cancel != nil {
 		return fmt.Errorf("unhandled cancel")
 	}
@@ -68,58 +138,188 @@ func (c *Client) start(a Application) error {
 	cmd := exec.Command(a.Exec.Path, a.Exec.Args...)
 	cmd.Dir = a.Exec.WorkingDirectory
 
-	cout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	cerr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Start(); err != nil {
-		return err
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer func() {
+		if err != nil {
+			cancelFunc()
+		}
+	}()
+	ch := c.lines
+	maxLineLength := a.Exec.MaxLineLength
+	if maxLineLength <= 0 {
+		maxLineLength = DefaultMaxLineLength
 	}
-
-	ch := make(chan string)
-
-	go func(dst chan<- string, src io.ReadCloser) {
-		scanner := bufio.NewScanner(src)
-		for scanner.Scan() {
-			dst <- scanner.Text()
+	if a.Exec.TTY {
+		// In a terminal stdout and stderr are the same, so there is no "!" mark here.
+		out, err := c.startTerminal(cmd)
+		if err != nil {
+			return err
+		}
+		c.outputs = []io.Closer{out}
+		go c.readLines(ctx, ch, out, "", maxLineLength)
+	} else {
+		// Pipes of our own rather than StdoutPipe, which cmd.Wait closes, so that reaping never waits for readers.
+		cout, wout, err := os.Pipe()
+		if err != nil {
+			return err
 		}
-	}(ch, cout)
-	go func(dst chan<- string, src io.ReadCloser) {
-		scanner := bufio.NewScanner(src)
-		for scanner.Scan() {
-			dst <- "!" + scanner.Text() // I just like it, comparing to use less stable bold red style.
+		cerr, werr, err := os.Pipe()
+		if err != nil {
+			closeFiles(cout, wout)
+			return err
+		}
+		cmd.Stdout, cmd.Stderr = wout, werr
+		if a.Exec.Stdin {
+			cin, err := cmd.StdinPipe()
+			if err != nil {
+				closeFiles(cout, wout, cerr, werr)
+				return err
+			}
+			c.stdin = cin
 		}
-	}(ch, cerr)
+
+		err = cmd.Start()
+		// The app holds its own copies of write ends, the reads end on EOF once it and its children exit.
+		closeFiles(wout, werr)
+		if err != nil {
+			closeFiles(cout, cerr)
+			return err
+		}
+
+		c.outputs = []io.Closer{cout, cerr}
+		go c.readLines(ctx, ch, cout, "", maxLineLength)
+		go c.readLines(ctx, ch, cerr, "!", maxLineLength) // I just like it, comparing to use less stable bold red style.
+	}
+	c.process = cmd.Process
+	c.events.add(EventStart, fmt.Sprintf("pid %d", cmd.Process.Pid))
+	go c.wait(cmd)
 
 	fp, err := os.Create(a.AbsoluteRedirectPath())
 	if err != nil {
 		return err
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	c.ctx = ctx
 	c.cancel = cancelFunc
 	go c.tee(ctx, ch, fp)
 	return nil
 }
 
+func closeFiles(files ...*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// readLines wraps readLines and records the error in events.
+func (c *Client) readLines(ctx context.Context, dst chan<- string, src io.Reader, prefix string, maxLength int) {
+	if err := readLines(ctx, dst, src, prefix, maxLength); err != nil {
+		slog.Warn("read output", "appID", c.appID, "prefix", prefix, "err", err)
+		c.events.add(EventOutputError, err.Error())
+	}
+}
+
+// Query returns the raw lines stored.
 func (c *Client) Query() []string {
-	if c.cancel == nil {
+	records := c.QueryRecords()
+	if records == nil {
 		return nil
 	}
-	ch := make(chan []string)
-	c.query <- ch
-	return <-ch
+	ret := make([]string, len(records))
+	for i, r := range records {
+		ret[i] = r.Line
+	}
+	return ret
+}
+
+func (c *Client) QueryRecords() []Record {
+	c.stdinMu.Lock()
+	terminated := c.cancel == nil
+	c.stdinMu.Unlock()
+	if terminated {
+		return nil
+	}
+	ch := make(chan []Record)
+	select {
+	case c.query <- ch:
+		return <-ch
+	case <-c.ctx.Done():
+		return nil
+	}
+}
+
+// wait reaps the app once it exits, and records EventExit or EventCrash.
+// It does not wait for readers, which never see EOF while a child of the app holds the output.
+func (c *Client) wait(cmd *exec.Cmd) {
+	err := cmd.Wait()
+	var e *exec.ExitError
+	switch {
+	case err == nil:
+		c.events.add(EventExit, cmd.ProcessState.String())
+	case errors.As(err, &e):
+		c.events.add(EventCrash, e.ProcessState.String())
+	default:
+		c.events.add(EventCrash, err.Error())
+	}
+}
+
+// signalQuit sends SIGQUIT to the app, which is the thread dump for a JVM.
+func (c *Client) signalQuit() error {
+	return c.process.Signal(syscall.SIGQUIT)
+}
+
+// Events returns the recent events on the app.
+func (c *Client) Events() []Event {
+	return c.events.get()
+}
+
+// Input writes lines to stdin of the app, each one is also echoed to the output as an input line from username.
+// The echo starts with '>' and the username quoted by brackets, likes "!" on stderr.
+// The echo is queued before the write, so that it's always before the reply of the app.
+// A line shall not contain a newline, which would be more than one line to the app but one in the echo.
+func (c *Client) Input(username string, lines []string) error {
+	if c.stdin == nil {
+		return ErrNoStdin
+	}
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return ErrMultilineInput
+		}
+	}
+
+	c.stdinMu.Lock()
+	defer c.stdinMu.Unlock()
+	if c.cancel == nil {
+		return ErrTerminated
+	}
+	for _, line := range lines {
+		select {
+		case c.lines <- fmt.Sprintf(">[%s]%s", username, line):
+		case <-c.ctx.Done():
+			return ErrTerminated
+		}
+		if _, err := io.WriteString(c.stdin, line+"\n"); err != nil {
+			return fmt.Errorf("write stdin: %w", err)
+		}
+	}
+	return nil
 }
 
 // Terminate stops the running of helper, which little relevant to the started app.
 // It means the tee mechanism stops pipe output to RedirectPath and the Query is no longer available.
+// Readers of the output end as well, or they and the pipes would leak on each restart.
 func (c *Client) Terminate() {
+	// Cancel before the lock, which Input could hold while waiting on tee.
 	c.cancel()
+	c.stdinMu.Lock()
 	c.cancel = nil
-	close(c.query)
+	c.stdinMu.Unlock()
+	for _, output := range c.outputs {
+		_ = output.Close()
+	}
+	if c.term != nil {
+		// The app keeps running, but attached ones would wait forever on the terminal without tee.
+		c.term.close()
+		_ = c.term.ptmx.Close()
+	}
 }
@@ -0,0 +1,301 @@
+package application
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitOutput polls Query until it has at least n lines, or gives up after a second.
+func waitOutput(c *Client, n int) []string {
+	var got []string
+	for i := 0; i < 100; i++ {
+		got = c.Query()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return got
+}
+
+func TestClient_Input(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "cat",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "cat",
+			RedirectPath:     "output",
+			Stdin:            true,
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Terminate()
+
+	if err := c.Input("alice", []string{"hello", "bad\nrm -rf /"}); err != ErrMultilineInput {
+		t.Errorf("Input() with newline err = %v, want %v", err, ErrMultilineInput)
+	}
+	if err := c.Input("alice", []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{">[alice]hello", "hello"}
+	if got := waitOutput(c, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() got = %v, want %v", got, want)
+	}
+}
+
+func TestClient_InputWithoutStdin(t *testing.T) {
+	dir := t.TempDir()
+	app := Application{
+		ID:   1,
+		Name: "true",
+		Exec: Exec{
+			WorkingDirectory: dir,
+			Path:             "true",
+			RedirectPath:     filepath.Join(dir, "output"),
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Terminate()
+
+	if err := c.Input("alice", []string{"hello"}); err != ErrNoStdin {
+		t.Errorf("Input() err = %v, want %v", err, ErrNoStdin)
+	}
+}
+
+func TestClient_Terminal(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "cat",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "cat",
+			RedirectPath:     "output",
+			TTY:              true,
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Terminate()
+
+	output, detach, err := c.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detach()
+	if err := c.Resize(24, 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteTerminal([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case chunk := <-output:
+		if !strings.Contains(string(chunk), "hello") {
+			t.Errorf("Attach() got %q, want hello", chunk)
+		}
+	case <-time.After(time.Second):
+		t.Error("Attach() got nothing")
+	}
+	// One from the terminal echo and another from cat.
+	want := []string{"hello", "hello"}
+	if got := waitOutput(c, len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Query() got = %v, want %v", got, want)
+	}
+}
+
+func TestClient_WaitReady(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		wantEvent EventType
+	}{
+		{"match", "^ready", EventReady},
+		{"timeout", "^never", EventReadyTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := Application{
+				ID:   1,
+				Name: "sh",
+				Exec: Exec{
+					WorkingDirectory: t.TempDir(),
+					Path:             "sh",
+					Args:             []string{"-c", "echo booting; sleep 0.1; echo ready now; sleep 5"},
+					RedirectPath:     "output",
+				},
+				ReadyPattern: tt.pattern,
+				ReadyTimeout: 500 * time.Millisecond,
+			}
+			c, err := NewClient(app, 10, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Terminate()
+
+			if got := c.Status(); got != StatusStarting {
+				t.Errorf("Status() before = %v, want %v", got, StatusStarting)
+			}
+			if got := c.ReadyTimeout(); got != app.ReadyTimeout {
+				t.Errorf("ReadyTimeout() = %v, want %v", got, app.ReadyTimeout)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := c.WaitReady(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Status(); got != StatusRunning {
+				t.Errorf("Status() after = %v, want %v", got, StatusRunning)
+			}
+			events := c.Events()
+			if got := events[len(events)-1].Type; got != tt.wantEvent {
+				t.Errorf("last event = %v, want %v", got, tt.wantEvent)
+			}
+		})
+	}
+}
+
+// A child inheriting the output keeps readers from EOF, which shall not keep the app from being reaped.
+func TestClient_WaitWithChildHoldingOutput(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "sh",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "sh",
+			Args:             []string{"-c", "sleep 5 & echo started"},
+			RedirectPath:     "output",
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Terminate()
+
+	for i := 0; i < 100; i++ {
+		events := c.Events()
+		if events[len(events)-1].Type == EventExit {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Events() got = %v, want %v in the end", c.Events(), EventExit)
+}
+
+// Restarting as the service does, kill then Terminate then a new one, shall not leak goroutines of the old.
+func TestClient_Restart(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "sh",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "sh",
+			Args:             []string{"-c", "sleep 5 & exec yes"},
+			RedirectPath:     "output",
+		},
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		c, err := NewClient(app, 10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitOutput(c, 1)
+		if err := c.process.Kill(); err != nil {
+			t.Fatal(err)
+		}
+		c.Terminate()
+	}
+	got := 0
+	for i := 0; i < 100; i++ {
+		if got = runtime.NumGoroutine(); got <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("NumGoroutine() = %v after restarts, want %v", got, before)
+}
+
+func TestClient_TerminateTerminal(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "cat",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "cat",
+			RedirectPath:     "output",
+			TTY:              true,
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, detach, err := c.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detach()
+	defer func() {
+		_ = c.process.Kill()
+	}()
+	c.Terminate()
+
+	select {
+	case _, ok := <-output:
+		if ok {
+			t.Error("Attach() got output after Terminate")
+		}
+	case <-time.After(time.Second):
+		t.Error("Attach() not closed on Terminate")
+	}
+	if _, _, err := c.Attach(); err != ErrTerminated {
+		t.Errorf("Attach() after Terminate err = %v, want %v", err, ErrTerminated)
+	}
+}
+
+func TestClient_InputTerminated(t *testing.T) {
+	app := Application{
+		ID:   1,
+		Name: "cat",
+		Exec: Exec{
+			WorkingDirectory: t.TempDir(),
+			Path:             "cat",
+			RedirectPath:     "output",
+			Stdin:            true,
+		},
+	}
+	c, err := NewClient(app, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.process.Kill()
+	}()
+	done := make(chan error)
+	go func() {
+		done <- c.Input("alice", []string{"hello"})
+	}()
+	c.Terminate()
+	if err := <-done; err != nil && err != ErrTerminated {
+		t.Errorf("Input() during Terminate err = %v", err)
+	}
+	if err := c.Input("alice", []string{"hello"}); err != ErrTerminated {
+		t.Errorf("Input() after Terminate err = %v, want %v", err, ErrTerminated)
+	}
+}
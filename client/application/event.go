@@ -0,0 +1,86 @@
+package application
+
+import (
+	"amah/ring"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventStart EventType = "start"
+	EventExit  EventType = "exit"  // exit with zero status
+	EventCrash EventType = "crash" // exit with non-zero status or killed by signal
+	// EventRestart is not from a Client, but those restart the app on an Alert.
+	EventRestart      EventType = "restart"
+	EventOutputError  EventType = "output error"
+	EventReady        EventType = "ready"
+	EventReadyTimeout EventType = "ready timeout"
+	EventAlert        EventType = "alert"
+)
+
+// Event is something happened on the app that worth a record, other than its output.
+type Event struct {
+	Time    time.Time
+	AppID   int
+	Type    EventType
+	Message string
+	User    string `json:",omitempty"` // who makes it happen, empty if it's not from a user
+	Alert   *Alert `json:",omitempty"` // only on EventAlert
+}
+
+// Listener is notified on every Event of a Client. It's called in the goroutine where the event happens,
+// which could be the tee loop, so it shall not block.
+type Listener func(e Event)
+
+// eventHistory keeps the recent events, it's small and rare so a mutex is enough.
+type eventHistory struct {
+	appID    int
+	mu       sync.Mutex
+	buf      ring.Ring[Event]
+	listener Listener // nullable
+}
+
+func newEventHistory(appID int, capacity int, listener Listener) *eventHistory {
+	return &eventHistory{
+		appID:    appID,
+		mu:       sync.Mutex{},
+		buf:      ring.New[Event](capacity),
+		listener: listener,
+	}
+}
+
+func (h *eventHistory) add(t EventType, message string) {
+	h.addEvent(Event{
+		Time:    time.Now(),
+		AppID:   h.appID,
+		Type:    t,
+		Message: message,
+	})
+}
+
+func (h *eventHistory) addAlert(alert Alert) {
+	h.addEvent(Event{
+		Time:    time.Now(),
+		AppID:   h.appID,
+		Type:    EventAlert,
+		Message: alert.Rule,
+		Alert:   &alert,
+	})
+}
+
+func (h *eventHistory) addEvent(e Event) {
+	h.mu.Lock()
+	h.buf.Add(e)
+	h.mu.Unlock()
+	if h.listener != nil {
+		h.listener(e)
+	}
+}
+
+func (h *eventHistory) get() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.buf.Get()
+}
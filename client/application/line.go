@@ -0,0 +1,69 @@
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultMaxLineLength is the same as the limit of bufio.Scanner, which was used before.
+const DefaultMaxLineLength = bufio.MaxScanTokenSize
+
+// readLines sends each line from src to dst with the prefix, until src ends or ctx is Done.
+// Different from bufio.Scanner, a line longer than maxLength would be truncated with a marker rather than stop,
+// so that the app never blocks on a full pipe. Invalid UTF-8 is replaced, and the last line without
+// a tailing newline is also sent. The returned error is nil on io.EOF, or once ctx is Done.
+func readLines(ctx context.Context, dst chan<- string, src io.Reader, prefix string, maxLength int) error {
+	send := func(line string) bool {
+		select {
+		case dst <- prefix + line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	r := bufio.NewReader(src)
+	var line []byte
+	dropped := 0
+	for {
+		chunk, err := r.ReadSlice('\n')
+		complete := err == nil
+		if complete {
+			chunk = chunk[:len(chunk)-1]
+		}
+		// The chunk is only valid until next read, so append always copies.
+		n := min(max(maxLength-len(line), 0), len(chunk))
+		line = append(line, chunk[:n]...)
+		dropped += len(chunk) - n
+
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			if (len(line) > 0 || dropped > 0) && !send(formatLine(line, dropped)) {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if complete {
+			if !send(formatLine(line, dropped)) {
+				return nil
+			}
+			line = line[:0]
+			dropped = 0
+		}
+	}
+}
+
+func formatLine(line []byte, dropped int) string {
+	// A terminal translates "\n" to "\r\n", trim the tail so that it looks the same as those from pipe.
+	s := strings.ToValidUTF8(string(bytes.TrimSuffix(line, []byte("\r"))), "�")
+	if dropped > 0 {
+		s += fmt.Sprintf("…[%d bytes truncated]", dropped)
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		maxLength int
+		want      []string
+	}{
+		{"happy path", "a\nbc\n", 10, []string{"a", "bc"}},
+		{"empty line", "\n\n", 10, []string{"", ""}},
+		{"partial at end", "a\nbc", 10, []string{"a", "bc"}},
+		{"crlf", "a\r\nb\r\n", 10, []string{"a", "b"}},
+		{"truncated", "abcdef\ngh\n", 4, []string{"abcd…[2 bytes truncated]", "gh"}},
+		{"truncated partial", "abcdef", 4, []string{"abcd…[2 bytes truncated]"}},
+		{"longer than buffer", strings.Repeat("x", 10000) + "\n", 5, []string{"xxxxx…[9995 bytes truncated]"}},
+		{"invalid utf8", "a\xffb\n", 10, []string{"a�b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan string, 10)
+			if err := readLines(context.Background(), ch, strings.NewReader(tt.input), "", tt.maxLength); err != nil {
+				t.Fatal(err)
+			}
+			close(ch)
+			var got []string
+			for line := range ch {
+				got = append(got, line)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readLines() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type failReader struct{}
+
+func (failReader) Read(_ []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func Test_readLinesError(t *testing.T) {
+	ch := make(chan string, 1)
+	r := io.MultiReader(strings.NewReader("abc"), failReader{})
+	if err := readLines(context.Background(), ch, r, "!", 10); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readLines() err = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if got := <-ch; got != "!abc" {
+		t.Errorf("readLines() got = %q, want %q", got, "!abc")
+	}
+}
+
+func Test_readLinesDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// No one receives, which shall not block once ctx is Done.
+	if err := readLines(ctx, make(chan string), strings.NewReader("abc\ndef\n"), "", 10); err != nil {
+		t.Errorf("readLines() err = %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const DefaultReadyTimeout = time.Minute
+
+type Status string
+
+const (
+	StatusStarting Status = "Starting"
+	// StatusRunning is after Application.ReadyPattern matched or its timeout elapsed, or just started if no pattern.
+	StatusRunning Status = "Running"
+)
+
+// readiness tracks whether the app is ready by its output, the watch is done in tee.
+type readiness struct {
+	pattern *regexp.Regexp // nil if no need to watch
+	timeout time.Duration
+	ready   chan struct{} // closed once ready
+}
+
+func newReadiness(app Application) (*readiness, error) {
+	ret := &readiness{
+		pattern: nil,
+		timeout: app.ReadyTimeout,
+		ready:   make(chan struct{}),
+	}
+	if app.ReadyPattern == "" {
+		close(ret.ready)
+		return ret, nil
+	}
+	pattern, err := regexp.Compile(app.ReadyPattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad ready pattern: %w", err)
+	}
+	ret.pattern = pattern
+	if ret.timeout <= 0 {
+		ret.timeout = DefaultReadyTimeout
+	}
+	return ret, nil
+}
+
+// watch returns true if line makes it ready. Input echoes are ignored as they are not from the app.
+func (r *readiness) watch(line string) bool {
+	if r.pattern == nil || strings.HasPrefix(line, ">") {
+		return false
+	}
+	select {
+	case <-r.ready:
+		return false
+	default:
+	}
+	return r.pattern.MatchString(line)
+}
+
+func (r *readiness) status() Status {
+	select {
+	case <-r.ready:
+		return StatusRunning
+	default:
+		return StatusStarting
+	}
+}
+
+// Status tells whether the app is still starting.
+func (c *Client) Status() Status {
+	return c.readiness.status()
+}
+
+// ReadyTimeout is how long the app could be StatusStarting, zero if it's never.
+func (c *Client) ReadyTimeout() time.Duration {
+	if c.readiness.pattern == nil {
+		return 0
+	}
+	return c.readiness.timeout
+}
+
+// WaitReady blocks until Status is no longer StatusStarting or ctx is done.
+func (c *Client) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.readiness.ready:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
@@ -0,0 +1,199 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type LogFormat string
+
+const (
+	LogFormatPlain  LogFormat = "plain"
+	LogFormatJSON   LogFormat = "json"
+	LogFormatLogfmt LogFormat = "logfmt"
+)
+
+// Record is an output line with fields parsed as Exec.LogFormat.
+// If the line is not of the format, only Line and Message are filled.
+type Record struct {
+	Line    string            // the raw line, with the "!" or ">" mark as it was.
+	Level   string            `json:",omitempty"`
+	Logger  string            `json:",omitempty"`
+	Message string            `json:",omitempty"`
+	Fields  map[string]string `json:",omitempty"` // the other fields
+}
+
+// The first one found is used, the others are left in Fields.
+var levelKeys = []string{"level", "lvl", "severity", "log.level"}
+var loggerKeys = []string{"logger", "logger_name", "log.logger"}
+var messageKeys = []string{"message", "msg"}
+
+func parseRecord(line string, format LogFormat) Record {
+	ret := Record{Line: line, Message: line}
+	// Input echoes are from us, never in the format of the app.
+	if strings.HasPrefix(line, ">") {
+		return ret
+	}
+	content := strings.TrimPrefix(line, "!")
+
+	var fields map[string]string
+	var ok bool
+	switch format {
+	case LogFormatJSON:
+		fields, ok = parseJSONFields(content)
+	case LogFormatLogfmt:
+		fields, ok = parseLogfmtFields(content)
+	}
+	if !ok {
+		return ret
+	}
+	ret.Level = takeFirst(fields, levelKeys)
+	ret.Logger = takeFirst(fields, loggerKeys)
+	if ret.Message = takeFirst(fields, messageKeys); ret.Message == "" {
+		ret.Message = content
+	}
+	if len(fields) > 0 {
+		ret.Fields = fields
+	}
+	return ret
+}
+
+func takeFirst(fields map[string]string, keys []string) string {
+	for _, key := range keys {
+		if v, ok := fields[key]; ok {
+			delete(fields, key)
+			return v
+		}
+	}
+	return ""
+}
+
+func parseJSONFields(s string) (map[string]string, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, false
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil, false
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			ret[k] = v
+		case nil:
+			ret[k] = ""
+		case map[string]any, []any:
+			// Nested ones are kept as JSON, as they were.
+			data, _ := json.Marshal(v)
+			ret[k] = string(data)
+		default:
+			ret[k] = fmt.Sprint(v)
+		}
+	}
+	return ret, true
+}
+
+// parseLogfmtFields parses lines like `level=info msg="hello world" id=1`.
+// A key without value is treated as empty. It fails only if there is no key=value pair at all.
+func parseLogfmtFields(s string) (map[string]string, bool) {
+	ret := make(map[string]string)
+	paired := false
+	i := 0
+	for i < len(s) {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		begin := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' {
+			i++
+		}
+		key := s[begin:i]
+		if key == "" {
+			if i < len(s) && s[i] == '=' {
+				return nil, false
+			}
+			break
+		}
+		if i >= len(s) || s[i] != '=' {
+			ret[key] = ""
+			continue
+		}
+		i++ // skip '='
+		paired = true
+		if i < len(s) && s[i] == '"' {
+			var sb strings.Builder
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					switch s[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(s[i])
+					}
+				} else {
+					sb.WriteByte(s[i])
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, false // unclosed quote
+			}
+			i++ // skip '"'
+			ret[key] = sb.String()
+		} else {
+			begin = i
+			for i < len(s) && s[i] != ' ' {
+				i++
+			}
+			ret[key] = s[begin:i]
+		}
+	}
+	return ret, paired
+}
+
+// RecordFilter selects records on Level and Fields, empty ones match all.
+type RecordFilter struct {
+	Levels []string          // any of them matches, case-insensitive
+	Fields map[string]string // all of them shall match, Level Logger and Message are also available as keys
+}
+
+func (f RecordFilter) Match(r Record) bool {
+	if len(f.Levels) > 0 {
+		found := false
+		for _, level := range f.Levels {
+			if strings.EqualFold(level, r.Level) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range f.Fields {
+		var got string
+		switch k {
+		case "level":
+			got = r.Level
+		case "logger":
+			got = r.Logger
+		case "message":
+			got = r.Message
+		default:
+			var ok bool
+			if got, ok = r.Fields[k]; !ok {
+				return false
+			}
+		}
+		if got != v {
+			return false
+		}
+	}
+	return true
+}
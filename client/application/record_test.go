@@ -0,0 +1,73 @@
+package application
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		format LogFormat
+		want   Record
+	}{
+		{"plain", "hello", LogFormatPlain, Record{Line: "hello", Message: "hello"}},
+		{"json", `{"level":"INFO","logger_name":"a.B","message":"hi","thread":"main","n":1}`, LogFormatJSON, Record{
+			Line:    `{"level":"INFO","logger_name":"a.B","message":"hi","thread":"main","n":1}`,
+			Level:   "INFO",
+			Logger:  "a.B",
+			Message: "hi",
+			Fields:  map[string]string{"thread": "main", "n": "1"},
+		}},
+		{"json on stderr", `!{"level":"ERROR","msg":"oops"}`, LogFormatJSON, Record{
+			Line:    `!{"level":"ERROR","msg":"oops"}`,
+			Level:   "ERROR",
+			Message: "oops",
+		}},
+		{"not json", "Started Application in 12.3 seconds", LogFormatJSON, Record{
+			Line:    "Started Application in 12.3 seconds",
+			Message: "Started Application in 12.3 seconds",
+		}},
+		{"logfmt", `level=warn msg="disk \"low\"" free=3%`, LogFormatLogfmt, Record{
+			Line:    `level=warn msg="disk \"low\"" free=3%`,
+			Level:   "warn",
+			Message: `disk "low"`,
+			Fields:  map[string]string{"free": "3%"},
+		}},
+		{"not logfmt", "just words", LogFormatLogfmt, Record{Line: "just words", Message: "just words"}},
+		{"input echo", `>[alice]{"level":"INFO"}`, LogFormatJSON, Record{
+			Line:    `>[alice]{"level":"INFO"}`,
+			Message: `>[alice]{"level":"INFO"}`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRecord(tt.line, tt.format); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRecord() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordFilter_Match(t *testing.T) {
+	r := Record{Level: "ERROR", Logger: "a.B", Message: "oops", Fields: map[string]string{"thread": "main"}}
+	tests := []struct {
+		name   string
+		filter RecordFilter
+		want   bool
+	}{
+		{"empty", RecordFilter{}, true},
+		{"level", RecordFilter{Levels: []string{"warn", "error"}}, true},
+		{"other level", RecordFilter{Levels: []string{"info"}}, false},
+		{"field", RecordFilter{Fields: map[string]string{"thread": "main", "logger": "a.B"}}, true},
+		{"missing field", RecordFilter{Fields: map[string]string{"user": ""}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(r); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package application
+
+import (
+	"errors"
+	"github.com/creack/pty"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+var ErrNoTerminal = errors.New("tty not enabled")
+
+// terminal holds the pseudo-terminal master of an app started with Exec.TTY,
+// and broadcasts raw output on it to attached viewers.
+type terminal struct {
+	ptmx      *os.File
+	mu        sync.Mutex // guard attachers and closed
+	attachers map[chan []byte]struct{}
+	closed    bool
+}
+
+// startTerminal starts cmd in a pseudo-terminal, and returns a reader on the output of it.
+// Raw output would be broadcast to those from Attach, while the returned one is for line-based tee.
+func (c *Client) startTerminal(cmd *exec.Cmd) (io.ReadCloser, error) {
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.term = &terminal{
+		ptmx:      ptmx,
+		mu:        sync.Mutex{},
+		attachers: make(map[chan []byte]struct{}),
+		closed:    false,
+	}
+	c.stdin = ptmx
+
+	pr, pw := io.Pipe()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := ptmx.Read(buf)
+			if n > 0 {
+				chunk := make([]byte, n)
+				copy(chunk, buf[:n])
+				c.term.broadcast(chunk)
+				// The pipe never fails before we close it.
+				_, _ = pw.Write(chunk)
+			}
+			if err != nil {
+				// Linux returns EIO once the app exits and no one holds the slave side.
+				slog.Info("terminal ends", "appID", c.appID, "err", err)
+				c.term.close()
+				_ = ptmx.Close()
+				_ = pw.Close()
+				return
+			}
+		}
+	}()
+	return pr, nil
+}
+
+// broadcast sends chunk to every attacher. One who is too slow to keep up would be detached,
+// as dropping some bytes in the middle makes a terminal corrupted.
+func (t *terminal) broadcast(chunk []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ch := range t.attachers {
+		select {
+		case ch <- chunk:
+		default:
+			delete(t.attachers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (t *terminal) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for ch := range t.attachers {
+		delete(t.attachers, ch)
+		close(ch)
+	}
+}
+
+// Attach subscribes the raw output of the terminal, the channel closes when the app exits or detach is called.
+func (c *Client) Attach() (output <-chan []byte, detach func(), err error) {
+	t := c.term
+	if t == nil {
+		return nil, nil, ErrNoTerminal
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, ErrTerminated
+	}
+	ch := make(chan []byte, 64)
+	t.attachers[ch] = struct{}{}
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.attachers[ch]; ok {
+			delete(t.attachers, ch)
+			close(ch)
+		}
+	}, nil
+}
+
+// WriteTerminal writes raw data as keystrokes to the terminal, without echo as Input does.
+// The terminal itself would echo if the app does not disable it.
+func (c *Client) WriteTerminal(data []byte) error {
+	if c.term == nil {
+		return ErrNoTerminal
+	}
+	c.stdinMu.Lock()
+	defer c.stdinMu.Unlock()
+	_, err := c.term.ptmx.Write(data)
+	return err
+}
+
+// Resize changes the window size of the terminal.
+func (c *Client) Resize(rows, cols uint16) error {
+	if c.term == nil {
+		return ErrNoTerminal
+	}
+	return pty.Setsize(c.term.ptmx, &pty.Winsize{Rows: rows, Cols: cols})
+}
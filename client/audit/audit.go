@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is one line in the audit log, chained by hash with the previous one so that tamper is evident.
+type Record struct {
+	Time    time.Time
+	User    string `json:",omitempty"` // empty if not authenticated
+	TokenID string `json:",omitempty"` // a digest of the token, the token itself is a credential
+	Cert    string `json:",omitempty"` // identity of the client certificate authenticated on
+	Source  string // IP of the client
+	Action  string
+	Target  string `json:",omitempty"`
+	Outcome int    // HTTP status code
+	Error   string `json:",omitempty"`
+	Prev    string // Hash of the previous Record, empty for the first one
+	Hash    string `json:",omitempty"` // sha256 of the JSON of this Record without Hash
+}
+
+func (r Record) digest() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// DigestToken makes the token printable in logs, with which one can correlate records but not use the token.
+func DigestToken(tokenID string) string {
+	if tokenID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(tokenID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DigestTokenHash is DigestToken on the sha256 of the token in hex, likes auth.Token.Hash, as the token is gone.
+func DigestTokenHash(hash string) string {
+	return hash[:min(len(hash), 16)]
+}
+
+// Log is the append-only audit log on a file, each line a Record in JSON.
+type Log struct {
+	path string
+	mu   sync.Mutex // guard last and file
+	last string
+	file *os.File
+}
+
+// Open opens the log on path, creates it if not exists. It reads the file to continue the chain.
+func Open(path string) (*Log, error) {
+	last := ""
+	if err := scan(path, func(_ int, r Record) error {
+		last = r.Hash
+		return nil
+	}); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, mu: sync.Mutex{}, last: last, file: file}, nil
+}
+
+// Append fills Prev and Hash of r, and writes it to disk before return.
+func (l *Log) Append(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Prev = l.last
+	hash, err := r.digest()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.last = hash
+	return nil
+}
+
+// Query returns records that match, the latest limit ones if limit is positive.
+func (l *Log) Query(match func(r Record) bool, limit int) ([]Record, error) {
+	ret := make([]Record, 0)
+	err := scan(l.path, func(_ int, r Record) error {
+		if match(r) {
+			ret = append(ret, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(ret) > limit {
+		ret = ret[len(ret)-limit:]
+	}
+	return ret, nil
+}
+
+// Verify checks the chain in file on path, returns how many records are good and the first error if any.
+func Verify(path string) (count int, err error) {
+	prev := ""
+	err = scan(path, func(lineNumber int, r Record) error {
+		if r.Prev != prev {
+			return fmt.Errorf("line %d: chain broken, prev %s want %s", lineNumber, r.Prev, prev)
+		}
+		hash, err := r.digest()
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if hash != r.Hash {
+			return fmt.Errorf("line %d: hash mismatch, got %s want %s", lineNumber, r.Hash, hash)
+		}
+		prev = r.Hash
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func scan(path string, f func(lineNumber int, r Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	scanner := bufio.NewScanner(file)
+	for i := 1; scanner.Scan(); i++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("line %d: %w", i, err)
+		}
+		if err := f(i, r); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
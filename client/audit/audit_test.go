@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		{Time: time.Now(), User: "alice", Source: "10.0.0.1", Action: "Login", Outcome: 200},
+		{Time: time.Now(), User: "alice", Source: "10.0.0.1", Action: "DeleteProcess", Target: "42", Outcome: 404},
+	}
+	for _, r := range records {
+		if err := l.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reopen shall continue the chain.
+	if l, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Record{Time: time.Now(), User: "ben", Action: "Login", Outcome: 403}); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := Verify(path); err != nil || count != 3 {
+		t.Errorf("Verify() = %d, %v, want 3 and nil", count, err)
+	}
+	got, err := l.Query(func(r Record) bool { return r.User == "alice" }, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Action != "DeleteProcess" {
+		t.Errorf("Query() = %+v, want the last of alice", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), `"Target":"42"`, `"Target":"43"`, 1)
+	if err := os.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := Verify(path); err == nil || count != 1 {
+		t.Errorf("Verify() on tampered = %d, %v, want 1 and an error", count, err)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// disabledPrefix on EncryptedPassword disables the account, as "!" in /etc/shadow locks one.
+const disabledPrefix = "!"
+
+var (
+	ErrDuplicatedUsername = errors.New("duplicated username")
+	ErrNoAccount          = errors.New("no account")
+	ErrBadUsername        = errors.New("bad username, shall be letters, digits, _, ., @ or -")
+	ErrLastAdmin          = errors.New("the last enabled admin")
+)
+
+var usernamePattern = regexp.MustCompile(`^[\w.@-]+$`)
+
+func (a Account) Disabled() bool {
+	return strings.HasPrefix(a.EncryptedPassword, disabledPrefix)
+}
+
+// accounts returns them in the order of shadow lines, which shall be called with accountMu locked.
+func (c *Client) accounts() []Account {
+	ret := make([]Account, 0, len(c.usernames))
+	for _, username := range c.usernames {
+		ret = append(ret, c.usernameToAccount[username])
+	}
+	return ret
+}
+
+// lastAdmin tells whether the account is the only enabled admin, which shall be called with accountMu locked.
+// Without it, no one could manage accounts but by editing the shadow file.
+func (c *Client) lastAdmin(account Account) bool {
+	if account.Role != RoleAdmin || account.Disabled() {
+		return false
+	}
+	for _, other := range c.usernameToAccount {
+		if other.Username != account.Username && other.Role == RoleAdmin && !other.Disabled() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) Accounts() []Account {
+	c.accountMu.RLock()
+	defer c.accountMu.RUnlock()
+	return c.accounts()
+}
+
+// replaceAccounts saves and takes accounts, which shall be called with accountMu locked.
+func (c *Client) replaceAccounts(accounts []Account) error {
+	usernames, usernameToAccount, err := indexAccounts(accounts)
+	if err != nil {
+		return err
+	}
+	if err := c.writeShadow(accounts); err != nil {
+		return err
+	}
+	c.usernames, c.usernameToAccount = usernames, usernameToAccount
+	return nil
+}
+
+// AddAccount adds one with password in the preferred hash.
+func (c *Client) AddAccount(username string, password string, role Role, appIDs []int) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: %q", ErrBadUsername, username)
+	}
+	encryptedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	if _, ok := c.usernameToAccount[username]; ok {
+		return fmt.Errorf("%w %s", ErrDuplicatedUsername, username)
+	}
+	return c.replaceAccounts(append(c.accounts(), Account{
+		Username:          username,
+		EncryptedPassword: encryptedPassword,
+		Role:              role,
+		AppIDs:            appIDs,
+		TOTPSecret:        "",
+		RecoveryCodes:     nil,
+	}))
+}
+
+// DeleteAccount removes the account and ends its sessions, but never the last enabled admin.
+func (c *Client) DeleteAccount(username string) error {
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrNoAccount, username)
+	}
+	if c.lastAdmin(account) {
+		return fmt.Errorf("%w %s can not be deleted", ErrLastAdmin, username)
+	}
+	var accounts []Account
+	for _, account := range c.accounts() {
+		if account.Username != username {
+			accounts = append(accounts, account)
+		}
+	}
+	if err := c.replaceAccounts(accounts); err != nil {
+		return err
+	}
+	return c.revokeTokensOf(username)
+}
+
+// SetDisabled disables or enables the account, whose sessions end on disable. The last enabled admin
+// can not be disabled.
+func (c *Client) SetDisabled(username string, disabled bool) error {
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrNoAccount, username)
+	}
+	if account.Disabled() == disabled {
+		return nil
+	}
+	if disabled && c.lastAdmin(account) {
+		return fmt.Errorf("%w %s can not be disabled", ErrLastAdmin, username)
+	}
+	if disabled {
+		account.EncryptedPassword = disabledPrefix + account.EncryptedPassword
+	} else {
+		account.EncryptedPassword = strings.TrimPrefix(account.EncryptedPassword, disabledPrefix)
+	}
+	if err := c.updateAccount(account); err != nil {
+		return err
+	}
+	if disabled {
+		return c.revokeTokensOf(username)
+	}
+	return nil
+}
+
+// SetPassword replaces the password, the old one shall have been checked by Auth if it's a change by the owner.
+func (c *Client) SetPassword(username string, password string) error {
+	encryptedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrNoAccount, username)
+	}
+	if account.Disabled() {
+		encryptedPassword = disabledPrefix + encryptedPassword
+	}
+	account.EncryptedPassword = encryptedPassword
+	return c.updateAccount(account)
+}
+
+func (c *Client) revokeTokensOf(username string) error {
+	_, err := c.tokens.DeleteFunc(func(t Token) bool {
+		return t.Username == username
+	})
+	return err
+}
+
+// Reload takes accounts in the shadow file, for changes by others. Sessions of those removed end.
+func (c *Client) Reload() error {
+	if c.shadowPath == "" {
+		return nil
+	}
+	info, err := os.Stat(c.shadowPath)
+	if err != nil {
+		return err
+	}
+	accounts, err := LoadAccounts(c.shadowPath)
+	if err != nil {
+		return err
+	}
+	usernames, usernameToAccount, err := indexAccounts(accounts)
+	if err != nil {
+		return fmt.Errorf("shadow file %s: %v", c.shadowPath, err)
+	}
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	c.usernames, c.usernameToAccount, c.shadowModTime = usernames, usernameToAccount, info.ModTime()
+	_, err = c.tokens.DeleteFunc(func(t Token) bool {
+		account, ok := usernameToAccount[t.Username]
+		return !ok || account.Disabled()
+	})
+	return err
+}
+
+// changed tells whether the shadow file has been modified since loaded or written by the Client.
+func (c *Client) changed() (modTime time.Time, ok bool) {
+	info, err := os.Stat(c.shadowPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	c.accountMu.RLock()
+	defer c.accountMu.RUnlock()
+	return info.ModTime(), !info.ModTime().Equal(c.shadowModTime)
+}
+
+// RunReloader reloads on changes of the shadow file every interval until ctx done.
+// A bad file is logged and ignored, the accounts in memory keep working.
+func (c *Client) RunReloader(ctx context.Context, interval time.Duration) {
+	if c.shadowPath == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			modTime, ok := c.changed()
+			if !ok {
+				continue
+			}
+			if err := c.Reload(); err != nil {
+				slog.Warn("reload shadow", "path", c.shadowPath, "err", err)
+				// Not again until it's changed once more.
+				c.accountMu.Lock()
+				c.shadowModTime = modTime
+				c.accountMu.Unlock()
+				continue
+			}
+			slog.Info("reload shadow", "path", c.shadowPath)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
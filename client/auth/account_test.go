@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_ManageAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddAccount("alice", "123456", RoleOperator, []int{1001}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddAccount("alice", "654321", RoleAdmin, nil); !errors.Is(err, ErrDuplicatedUsername) {
+		t.Errorf("AddAccount() duplicated err = %v, want %v", err, ErrDuplicatedUsername)
+	}
+	if err := c.AddAccount("a:b", "123456", RoleAdmin, nil); !errors.Is(err, ErrBadUsername) {
+		t.Errorf("AddAccount() bad username err = %v, want %v", err, ErrBadUsername)
+	}
+	if ok, _ := c.Auth("alice", "123456"); !ok {
+		t.Error("Auth() after add not ok")
+	}
+
+	token, _ := c.CreateToken("alice", "")
+	if err := c.SetDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Auth("alice", "123456"); ok {
+		t.Error("Auth() after disable ok")
+	}
+	if _, ok := c.FindValidToken(token.ID); ok {
+		t.Error("FindValidToken() after disable ok")
+	}
+	if err := c.SetPassword("alice", "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDisabled("alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Auth("alice", "abcdef"); !ok {
+		t.Error("Auth() on new password after enable not ok")
+	}
+
+	reloaded, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, ok := reloaded.FindAccount("alice"); !ok || account.Role != RoleOperator || account.Disabled() {
+		t.Errorf("saved account = %v, %v", account, ok)
+	}
+
+	if err := c.DeleteAccount("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteAccount("alice"); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("DeleteAccount() twice err = %v, want %v", err, ErrNoAccount)
+	}
+	if got := c.Accounts(); len(got) != 0 {
+		t.Errorf("Accounts() after delete = %v", got)
+	}
+}
+
+func TestClient_LastAdmin(t *testing.T) {
+	c, err := NewClient(nil, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"alice", "ben"} {
+		if err := c.AddAccount(username, "123456", RoleAdmin, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddAccount("carol", "123456", RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDisabled("alice", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDisabled("ben", true); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("SetDisabled() on last admin err = %v, want %v", err, ErrLastAdmin)
+	}
+	if err := c.DeleteAccount("ben"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("DeleteAccount() on last admin err = %v, want %v", err, ErrLastAdmin)
+	}
+	if err := c.DeleteAccount("carol"); err != nil {
+		t.Errorf("DeleteAccount() on operator err = %v", err)
+	}
+	if err := c.SetDisabled("alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteAccount("ben"); err != nil {
+		t.Errorf("DeleteAccount() on admin with another err = %v", err)
+	}
+}
+
+func TestClient_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow")
+	alice, _ := Register("alice", "123456", RoleAdmin, nil)
+	ben, _ := Register("ben", "123456", RoleViewer, nil)
+	if err := os.WriteFile(path, []byte(alice+"\n"+ben+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := c.CreateToken("ben", "")
+	if _, changed := c.changed(); changed {
+		t.Error("changed() just after load")
+	}
+
+	// Some file systems keep mtime in seconds.
+	later := time.Now().Add(time.Second)
+	if err := os.WriteFile(path, []byte(alice+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed := c.changed(); !changed {
+		t.Error("changed() after write by others not")
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.FindAccount("ben"); ok {
+		t.Error("FindAccount() removed one after reload ok")
+	}
+	if _, ok := c.FindValidToken(token.ID); ok {
+		t.Error("FindValidToken() of removed one after reload ok")
+	}
+	if _, changed := c.changed(); changed {
+		t.Error("changed() after reload")
+	}
+}
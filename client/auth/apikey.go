@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is what an APIKey could do.
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"   // processes, applications, output and events
+	ScopeStart  Scope = "start"  // start applications
+	ScopeStop   Scope = "stop"   // kill processes
+	ScopeDeploy Scope = "deploy" // reload the applications config
+)
+
+var scopes = []Scope{ScopeRead, ScopeStart, ScopeStop, ScopeDeploy}
+
+func (s Scope) Valid() bool {
+	return slices.Contains(scopes, s)
+}
+
+func ParseScopes(s string) ([]Scope, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ret []Scope
+	for _, one := range strings.Split(s, ",") {
+		if !Scope(one).Valid() {
+			return nil, fmt.Errorf("unknown scope %s", one)
+		}
+		ret = append(ret, Scope(one))
+	}
+	return ret, nil
+}
+
+// APIKeyPrefix tells an API key from a token.
+const APIKeyPrefix = "amah_"
+
+// APIKey is a long-lived credential for automation. Only the hash of the key is kept.
+type APIKey struct {
+	Name     string
+	Hash     string `json:"-"` // sha256 in hex, the key itself is random enough that needs no salt or bcrypt
+	Scopes   []Scope
+	AppIDs   []int     // the apps permitted to operate on, empty means all
+	ExpireAt time.Time // zero means never
+}
+
+var keyNamePattern = regexp.MustCompile(`^[\w.-]+$`)
+
+// NewAPIKey generates a key, which is returned only once here.
+func NewAPIKey(name string, scopes []Scope, appIDs []int, expireAt time.Time) (key string, ret APIKey, err error) {
+	if !keyNamePattern.MatchString(name) {
+		return "", APIKey{}, fmt.Errorf("bad key name %q, shall be letters, digits, _, . or -", name)
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", APIKey{}, err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(data)
+	return key, APIKey{
+		Name:     name,
+		Hash:     hashKey(key),
+		Scopes:   scopes,
+		AppIDs:   appIDs,
+		ExpireAt: expireAt,
+	}, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIKey parses a line as name:hash:scopes:appIDs:expireAt, where scopes and appIDs are comma separated,
+// and expireAt is in unix seconds with 0 as never.
+func newAPIKey(line string) (APIKey, error) {
+	parts := strings.Split(line, ":")
+	if len(parts) != 5 {
+		return APIKey{}, fmt.Errorf("bad key line %s", line)
+	}
+	scopes, err := ParseScopes(parts[2])
+	if err != nil {
+		return APIKey{}, fmt.Errorf("bad key line on %s: %v", parts[0], err)
+	}
+	appIDs, err := ParseAppIDs(parts[3])
+	if err != nil {
+		return APIKey{}, fmt.Errorf("bad key line on %s: %v", parts[0], err)
+	}
+	seconds, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("bad key line on %s: bad expireAt %s", parts[0], parts[4])
+	}
+	var expireAt time.Time
+	if seconds != 0 {
+		expireAt = time.Unix(seconds, 0)
+	}
+	return APIKey{
+		Name:     parts[0],
+		Hash:     parts[1],
+		Scopes:   scopes,
+		AppIDs:   appIDs,
+		ExpireAt: expireAt,
+	}, nil
+}
+
+// Line is the reverse of newAPIKey.
+func (k APIKey) Line() string {
+	var ss []string
+	for _, s := range k.Scopes {
+		ss = append(ss, string(s))
+	}
+	var seconds int64
+	if !k.ExpireAt.IsZero() {
+		seconds = k.ExpireAt.Unix()
+	}
+	return fmt.Sprintf("%s:%s:%s:%s:%d", k.Name, k.Hash, strings.Join(ss, ","), formatAppIDs(k.AppIDs), seconds)
+}
+
+func (k APIKey) Has(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// Permits tells whether the key could operate on the app.
+func (k APIKey) Permits(appID int) bool {
+	return len(k.AppIDs) == 0 || slices.Contains(k.AppIDs, appID)
+}
+
+func (k APIKey) expired(now time.Time) bool {
+	return !k.ExpireAt.IsZero() && now.After(k.ExpireAt)
+}
+
+var ErrDuplicatedKeyName = errors.New("duplicated key name")
+
+// KeyStore keeps APIKey in a file, one line each, which is rewritten on every change.
+// Changes by others, likes the command line, are taken before each change here and by RunReloader.
+type KeyStore struct {
+	path       string // empty means in memory only
+	mu         sync.RWMutex
+	hashToKey  map[string]APIKey
+	nameToHash map[string]string
+	modTime    time.Time // of the file when loaded or written, to find changes by others
+}
+
+// OpenKeyStore loads keys in path, a file not exist is taken as empty.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	ret := &KeyStore{
+		path:       path,
+		mu:         sync.RWMutex{},
+		hashToKey:  make(map[string]APIKey),
+		nameToHash: make(map[string]string),
+		modTime:    time.Time{},
+	}
+	if path == "" {
+		return ret, nil
+	}
+	if err := ret.load(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// load replaces keys by those in the file, which shall be called with mu locked.
+func (s *KeyStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.hashToKey, s.nameToHash, s.modTime = make(map[string]APIKey), make(map[string]string), time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	keys, err := parseKeys(file)
+	if err != nil {
+		return fmt.Errorf("parse key file %s: %v", s.path, err)
+	}
+	hashToKey, nameToHash := make(map[string]APIKey), make(map[string]string)
+	for _, k := range keys {
+		if _, ok := nameToHash[k.Name]; ok {
+			return fmt.Errorf("parse key file %s: %w %s", s.path, ErrDuplicatedKeyName, k.Name)
+		}
+		hashToKey[k.Hash] = k
+		nameToHash[k.Name] = k.Hash
+	}
+	s.hashToKey, s.nameToHash, s.modTime = hashToKey, nameToHash, info.ModTime()
+	return nil
+}
+
+// changed tells whether the file has been modified since loaded or written by the KeyStore.
+func (s *KeyStore) changed() bool {
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	return !modTime.Equal(s.modTime)
+}
+
+// reload takes the file if changed, so that a change here never overwrites the one by others.
+// It shall be called with mu locked.
+func (s *KeyStore) reload() error {
+	if s.path == "" || !s.changed() {
+		return nil
+	}
+	return s.load()
+}
+
+// RunReloader reloads on changes of the file every interval until ctx done, so that a key revoked by others
+// stops working. A bad file is logged, and the keys in memory keep working.
+func (s *KeyStore) RunReloader(ctx context.Context, interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			changed := s.changed()
+			s.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			s.mu.Lock()
+			err := s.load()
+			if err != nil {
+				// Not again until it's changed once more.
+				if info, e := os.Stat(s.path); e == nil {
+					s.modTime = info.ModTime()
+				}
+			}
+			s.mu.Unlock()
+			if err != nil {
+				slog.Warn("reload keys", "path", s.path, "err", err)
+				continue
+			}
+			slog.Info("reload keys", "path", s.path)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func parseKeys(reader io.Reader) ([]APIKey, error) {
+	var ret []APIKey
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		one, err := newAPIKey(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, one)
+	}
+	return ret, scanner.Err()
+}
+
+// FindValid returns the APIKey of key, if it exists and has not expired.
+func (s *KeyStore) FindValid(key string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret, ok := s.hashToKey[hashKey(key)]
+	if !ok || ret.expired(time.Now()) {
+		return APIKey{}, false
+	}
+	return ret, true
+}
+
+func (s *KeyStore) Find(name string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret, ok := s.hashToKey[s.nameToHash[name]]
+	return ret, ok
+}
+
+// List returns all keys ordered by name, including those expired.
+func (s *KeyStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list()
+}
+
+func (s *KeyStore) list() []APIKey {
+	ret := make([]APIKey, 0, len(s.hashToKey))
+	for _, k := range s.hashToKey {
+		ret = append(ret, k)
+	}
+	slices.SortFunc(ret, func(a, b APIKey) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return ret
+}
+
+func (s *KeyStore) Add(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if _, ok := s.nameToHash[k.Name]; ok {
+		return fmt.Errorf("%w %s", ErrDuplicatedKeyName, k.Name)
+	}
+	s.hashToKey[k.Hash] = k
+	s.nameToHash[k.Name] = k.Hash
+	if err := s.save(); err != nil {
+		delete(s.hashToKey, k.Hash)
+		delete(s.nameToHash, k.Name)
+		return err
+	}
+	return nil
+}
+
+// Revoke removes the key on name, returns false if not found.
+func (s *KeyStore) Revoke(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return false, err
+	}
+	hash, ok := s.nameToHash[name]
+	if !ok {
+		return false, nil
+	}
+	k := s.hashToKey[hash]
+	delete(s.hashToKey, hash)
+	delete(s.nameToHash, name)
+	if err := s.save(); err != nil {
+		s.hashToKey[hash] = k
+		s.nameToHash[name] = hash
+		return false, err
+	}
+	return true, nil
+}
+
+// save rewrites the file, with the key hash only.
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	var sb strings.Builder
+	for _, k := range s.list() {
+		sb.WriteString(k.Line())
+		sb.WriteString("\n")
+	}
+	if err := writeFileAtomic(s.path, []byte(sb.String())); err != nil {
+		return err
+	}
+	// So that it would not be taken as a change by others.
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
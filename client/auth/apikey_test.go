@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_newAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    APIKey
+		wantErr bool
+	}{
+		{"all", "ci:abc:read,start:1001,1002:1700000000",
+			APIKey{"ci", "abc", []Scope{ScopeRead, ScopeStart}, []int{1001, 1002}, time.Unix(1700000000, 0)}, false},
+		{"never expire", "ci:abc:deploy::0", APIKey{"ci", "abc", []Scope{ScopeDeploy}, nil, time.Time{}}, false},
+		{"bad scope", "ci:abc:root::0", APIKey{}, true},
+		{"bad expire", "ci:abc:read::soon", APIKey{}, true},
+		{"too few", "ci:abc:read", APIKey{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newAPIKey(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newAPIKey() got = %v, want %v", got, tt.want)
+			}
+			if err == nil && got.Line() != tt.line {
+				t.Errorf("Line() got = %v, want %v", got.Line(), tt.line)
+			}
+		})
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys")
+	store, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, k, err := NewAPIKey("ci", []Scope{ScopeRead}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(k); err != nil {
+		t.Fatal(err)
+	}
+	_, dup, _ := NewAPIKey("ci", []Scope{ScopeRead}, nil, time.Time{})
+	if err := store.Add(dup); !errors.Is(err, ErrDuplicatedKeyName) {
+		t.Errorf("Add() duplicated err = %v, want %v", err, ErrDuplicatedKeyName)
+	}
+	oldKey, old, _ := NewAPIKey("old", []Scope{ScopeRead}, nil, time.Now().Add(-time.Minute))
+	if err := store.Add(old); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.FindValid(key); !ok || got.Name != "ci" {
+		t.Errorf("FindValid() after reopen = %v, %v", got, ok)
+	}
+	if _, ok := reopened.FindValid(oldKey); ok {
+		t.Error("FindValid() on expired key ok")
+	}
+	if _, ok := reopened.FindValid(key + "x"); ok {
+		t.Error("FindValid() on wrong key ok")
+	}
+	if got := len(reopened.List()); got != 2 {
+		t.Errorf("List() len = %v, want 2", got)
+	}
+
+	if found, err := reopened.Revoke("ci"); err != nil || !found {
+		t.Errorf("Revoke() = %v, %v", found, err)
+	}
+	if found, _ := reopened.Revoke("ci"); found {
+		t.Error("Revoke() twice found")
+	}
+	again, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.FindValid(key); ok {
+		t.Error("FindValid() after revoke ok")
+	}
+
+	// The first store has not seen the revoke by another, which shall not come back on its next change.
+	// Some file systems keep mtime in seconds.
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	_, added, _ := NewAPIKey("new", []Scope{ScopeRead}, nil, time.Time{})
+	if err := store.Add(added); err != nil {
+		t.Fatal(err)
+	}
+	again, err = OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := again.FindValid(key); ok {
+		t.Error("FindValid() after revoke by another and add ok")
+	}
+	if _, ok := again.Find("new"); !ok {
+		t.Error("Find() on added after revoke by another not found")
+	}
+}
@@ -3,41 +3,133 @@ package auth
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"io"
+	"log/slog"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Account struct {
 	Username          string
 	EncryptedPassword string
+	Role              Role
+	AppIDs            []int    // the apps permitted to operate on, empty means all
+	TOTPSecret        string   // base32, empty means not enrolled
+	RecoveryCodes     []string // hashes of those not used yet, see hashRecoveryCode
+	TOTPStep          int64    // the last TOTP step used, which can not be used again even after restart
 }
 
+// Token is a login session. Only the hash of ID is kept in a TokenStore, likes APIKey,
+// so ID is only there on those returned by CreateToken and FindValidToken.
 type Token struct {
-	ID       string
-	ExpireAt time.Time
-	Username string
+	ID         string `json:"-"`
+	Hash       string // sha256 of ID in hex
+	ExpireAt   time.Time
+	Username   string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	Source     string // the IP where it's created
 }
 
+// newAccount parses a shadow line as username:encryptedPassword[:role[:appIDs[:totpSecret[:recoveryCodes[:totpStep]]]]],
+// where appIDs and recoveryCodes are comma separated.
+// Role defaults to RoleAdmin, so that those lines before roles keep what they could do.
 func newAccount(shadowLine string) (Account, error) {
 	parts := strings.Split(shadowLine, ":")
 	if len(parts) < 2 {
 		return Account{}, fmt.Errorf("bad shardow line %s", shadowLine)
 	}
-	return Account{
+	ret := Account{
 		Username:          parts[0],
 		EncryptedPassword: parts[1],
-	}, nil
+		Role:              RoleAdmin,
+		AppIDs:            nil,
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		role, err := ParseRole(parts[2])
+		if err != nil {
+			return Account{}, fmt.Errorf("bad shadow line on %s: %v", parts[0], err)
+		}
+		ret.Role = role
+	}
+	if len(parts) > 3 {
+		appIDs, err := ParseAppIDs(parts[3])
+		if err != nil {
+			return Account{}, fmt.Errorf("bad shadow line on %s: %v", parts[0], err)
+		}
+		ret.AppIDs = appIDs
+	}
+	if len(parts) > 4 {
+		ret.TOTPSecret = parts[4]
+	}
+	if len(parts) > 5 && parts[5] != "" {
+		ret.RecoveryCodes = strings.Split(parts[5], ",")
+	}
+	if len(parts) > 6 {
+		step, err := strconv.ParseInt(parts[6], 10, 64)
+		if err != nil {
+			return Account{}, fmt.Errorf("bad shadow line on %s: bad totp step %s", parts[0], parts[6])
+		}
+		ret.TOTPStep = step
+	}
+	return ret, nil
+}
+
+// ShadowLine is the reverse of newAccount.
+func (a Account) ShadowLine() string {
+	ret := fmt.Sprintf("%s:%s:%s:%s", a.Username, a.EncryptedPassword, a.Role, formatAppIDs(a.AppIDs))
+	if a.TOTPSecret != "" {
+		ret += fmt.Sprintf(":%s:%s:%d", a.TOTPSecret, strings.Join(a.RecoveryCodes, ","), a.TOTPStep)
+	}
+	return ret
+}
+
+// ParseAppIDs parses comma separated app IDs, empty means nil.
+func ParseAppIDs(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ret []int
+	for _, one := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(one)
+		if err != nil {
+			return nil, fmt.Errorf("bad app id %s", one)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+func formatAppIDs(appIDs []int) string {
+	var ids []string
+	for _, id := range appIDs {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return strings.Join(ids, ",")
+}
+
+// Permits tells whether the account could operate on the app.
+func (a Account) Permits(appID int) bool {
+	return len(a.AppIDs) == 0 || slices.Contains(a.AppIDs, appID)
 }
 
 type Client struct {
-	usernameToEncryptedPassword map[string]string
-	tokenIDToToken              map[string]Token
+	shadowPath        string // empty means accounts are not saved
+	accountMu         sync.RWMutex
+	usernames         []string // in the order of shadow lines
+	usernameToAccount map[string]Account
+	tokens            TokenStore
+	shadowModTime     time.Time // of the shadow file when loaded or written, to find changes by others
+	certRules         []CertRule
 }
 
 func LoadAccounts(shadowFilePath string) ([]Account, error) {
@@ -52,17 +144,96 @@ func LoadAccounts(shadowFilePath string) ([]Account, error) {
 	return ret, nil
 }
 
-func NewClient(accounts []Account) (*Client, error) {
-	usernameToEncryptedPassword := make(map[string]string)
+func indexAccounts(accounts []Account) (usernames []string, usernameToAccount map[string]Account, err error) {
+	usernameToAccount = make(map[string]Account)
 	for _, account := range accounts {
-		usernameToEncryptedPassword[account.Username] = account.EncryptedPassword
+		if _, ok := usernameToAccount[account.Username]; ok {
+			return nil, nil, fmt.Errorf("%w %s", ErrDuplicatedUsername, account.Username)
+		}
+		usernames = append(usernames, account.Username)
+		usernameToAccount[account.Username] = account
+	}
+	return usernames, usernameToAccount, nil
+}
+
+func NewClient(accounts []Account, tokens TokenStore) (*Client, error) {
+	usernames, usernameToAccount, err := indexAccounts(accounts)
+	if err != nil {
+		return nil, err
 	}
 	return &Client{
-		usernameToEncryptedPassword: usernameToEncryptedPassword,
-		tokenIDToToken:              make(map[string]Token),
+		shadowPath:        "",
+		accountMu:         sync.RWMutex{},
+		usernames:         usernames,
+		usernameToAccount: usernameToAccount,
+		tokens:            tokens,
+		shadowModTime:     time.Time{},
+		certRules:         nil,
 	}, nil
 }
 
+// LoadClient likes NewClient, but on accounts in the shadow file, which is rewritten on changes of them.
+func LoadClient(shadowPath string, tokens TokenStore) (*Client, error) {
+	accounts, err := LoadAccounts(shadowPath)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := NewClient(accounts, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("shadow file %s: %v", shadowPath, err)
+	}
+	ret.shadowPath = shadowPath
+	if info, err := os.Stat(shadowPath); err == nil {
+		ret.shadowModTime = info.ModTime()
+	}
+	return ret, nil
+}
+
+func (c *Client) FindAccount(username string) (Account, bool) {
+	c.accountMu.RLock()
+	defer c.accountMu.RUnlock()
+	account, ok := c.usernameToAccount[username]
+	return account, ok
+}
+
+// updateAccount replaces the account on its username and saves, which shall be called with accountMu locked.
+func (c *Client) updateAccount(account Account) error {
+	old, ok := c.usernameToAccount[account.Username]
+	if !ok {
+		return fmt.Errorf("no account %s", account.Username)
+	}
+	c.usernameToAccount[account.Username] = account
+	if err := c.saveShadow(); err != nil {
+		c.usernameToAccount[account.Username] = old
+		return err
+	}
+	return nil
+}
+
+// saveShadow shall be called with accountMu locked.
+func (c *Client) saveShadow() error {
+	return c.writeShadow(c.accounts())
+}
+
+func (c *Client) writeShadow(accounts []Account) error {
+	if c.shadowPath == "" {
+		return nil
+	}
+	var sb strings.Builder
+	for _, account := range accounts {
+		sb.WriteString(account.ShadowLine())
+		sb.WriteString("\n")
+	}
+	if err := writeFileAtomic(c.shadowPath, []byte(sb.String())); err != nil {
+		return err
+	}
+	// So that the reloader would not take it as a change by others.
+	if info, err := os.Stat(c.shadowPath); err == nil {
+		c.shadowModTime = info.ModTime()
+	}
+	return nil
+}
+
 func ParseShadow(reader io.Reader) ([]Account, error) {
 	var ret []Account
 	scanner := bufio.NewScanner(reader)
@@ -79,53 +250,160 @@ func ParseShadow(reader io.Reader) ([]Account, error) {
 var dummyEncryptedPasswordData, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
 
 func (c *Client) Auth(username, password string) (ok bool, err error) {
-	encryptedPassword, ok := c.usernameToEncryptedPassword[username]
-	encryptedPasswordData := []byte(encryptedPassword)
+	account, ok := c.FindAccount(username)
+	encryptedPassword := account.EncryptedPassword
+	if ok && account.Disabled() {
+		ok = false
+	}
 	if !ok {
 		// If 404, crypto/bcrypt: hashedSecret too short to be a bcrypted password.
 		// So use a dummy one to pass time-attack(check username exists through auth time cost).
-		encryptedPasswordData = dummyEncryptedPasswordData
+		encryptedPassword = string(dummyEncryptedPasswordData)
 	}
-	e := bcrypt.CompareHashAndPassword(encryptedPasswordData, []byte(password))
+	e := comparePassword(encryptedPassword, password)
 	if !ok {
 		return false, nil
 	}
 	if e != nil && !errors.Is(e, bcrypt.ErrMismatchedHashAndPassword) {
 		return false, e
 	}
+	if e == nil && !isPreferred(encryptedPassword) {
+		if err := c.rehash(username, encryptedPassword, password); err != nil {
+			// The password is right anyway, rehash again on next time.
+			slog.Warn("rehash password", "username", username, "err", err)
+		}
+	}
 	return e == nil, nil
 }
 
-func expireAt(now time.Time) time.Time {
-	return now.Add(10 * time.Minute)
+// rehash replaces the old hash in other formats with the preferred one, unless it has been changed meanwhile.
+func (c *Client) rehash(username string, old string, password string) error {
+	encryptedPassword, err := EncryptPassword(password)
+	if err != nil {
+		return err
+	}
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok || account.EncryptedPassword != old {
+		return nil
+	}
+	account.EncryptedPassword = encryptedPassword
+	return c.updateAccount(account)
 }
 
-func (c *Client) CreateToken(Username string) Token {
-	ret := Token{
-		ID:       uuid.NewString(),
-		ExpireAt: expireAt(time.Now()),
-		Username: Username,
+const (
+	TokenIdleTimeout = 10 * time.Minute // a token expires if not used in it
+	TokenMaxLifetime = 12 * time.Hour   // a token expires after it since created, however often used
+)
+
+// expireAt slides on each use, but never beyond TokenMaxLifetime.
+func expireAt(createdAt time.Time, now time.Time) time.Time {
+	ret := now.Add(TokenIdleTimeout)
+	if limit := createdAt.Add(TokenMaxLifetime); ret.After(limit) {
+		return limit
 	}
-	c.tokenIDToToken[ret.ID] = ret
 	return ret
 }
 
+func (c *Client) CreateToken(username string, source string) (Token, error) {
+	now := time.Now()
+	id := uuid.NewString()
+	ret := Token{
+		ID:         "",
+		Hash:       hashKey(id),
+		ExpireAt:   expireAt(now, now),
+		Username:   username,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		Source:     source,
+	}
+	if err := c.tokens.Put(ret); err != nil {
+		return Token{}, err
+	}
+	ret.ID = id
+	return ret, nil
+}
+
+// FindValidToken returns the token if not expired, whose expiration slides as it's used.
 func (c *Client) FindValidToken(id string) (t Token, ok bool) {
-	token, ok := c.tokenIDToToken[id]
-	if !ok {
-		return Token{}, false
+	now := time.Now()
+	t, ok = c.tokens.Update(hashKey(id), func(token Token) (Token, bool) {
+		if token.ExpireAt.Before(now) {
+			return Token{}, false
+		}
+		token.LastUsedAt = now
+		token.ExpireAt = expireAt(token.CreatedAt, now)
+		return token, true
+	})
+	if ok {
+		t.ID = id
 	}
-	if token.ExpireAt.Before(time.Now()) {
-		delete(c.tokenIDToToken, id)
-		return Token{}, false
+	return t, ok
+}
+
+// RevokeToken ends the token, returns false if not found.
+func (c *Client) RevokeToken(id string) (bool, error) {
+	return c.tokens.Delete(hashKey(id))
+}
+
+// RevokeOtherTokens ends those of the user but the one on keep, likes after a change of password.
+func (c *Client) RevokeOtherTokens(username string, keep string) (int, error) {
+	keepHash := hashKey(keep)
+	return c.tokens.DeleteFunc(func(t Token) bool {
+		return t.Username == username && t.Hash != keepHash
+	})
+}
+
+// RevokeTokenByHash ends the token on Token.Hash, likes one from Tokens, returns false if not found.
+func (c *Client) RevokeTokenByHash(hash string) (bool, error) {
+	return c.tokens.Delete(hash)
+}
+
+// Tokens returns those not expired ordered by CreatedAt, without ID.
+func (c *Client) Tokens() []Token {
+	now := time.Now()
+	ret := slices.DeleteFunc(c.tokens.List(), func(t Token) bool {
+		return t.ExpireAt.Before(now)
+	})
+	slices.SortFunc(ret, func(a, b Token) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return ret
+}
+
+// Sweep removes expired tokens, which would otherwise stay until used again.
+func (c *Client) Sweep(now time.Time) (count int, err error) {
+	return c.tokens.DeleteFunc(func(t Token) bool {
+		return t.ExpireAt.Before(now)
+	})
+}
+
+// RunSweeper sweeps every interval until ctx done.
+func (c *Client) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if _, err := c.Sweep(now); err != nil {
+				slog.Warn("sweep tokens", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
-	return token, true
 }
 
-func Register(username, password string) (shadowLine string, err error) {
-	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func Register(username, password string, role Role, appIDs []int) (shadowLine string, err error) {
+	encryptedPassword, err := EncryptPassword(password)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s:%s", username, encryptedPassword), nil
+	return Account{
+		Username:          username,
+		EncryptedPassword: encryptedPassword,
+		Role:              role,
+		AppIDs:            appIDs,
+	}.ShadowLine(), nil
 }
@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_Encrypt(t *testing.T) {
@@ -20,7 +22,7 @@ func Test_Encrypt(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			line, err := Register(tt.registerUsername, tt.registerPassword)
+			line, err := Register(tt.registerUsername, tt.registerPassword, RoleAdmin, nil)
 			if err != nil {
 				t.Error(err)
 				return
@@ -30,7 +32,7 @@ func Test_Encrypt(t *testing.T) {
 				t.Error(err)
 				return
 			}
-			service, err := NewClient([]Account{account})
+			service, err := NewClient([]Account{account}, NewMemoryTokenStore())
 			if err != nil {
 				t.Error(err)
 				return
@@ -46,3 +48,121 @@ func Test_Encrypt(t *testing.T) {
 		})
 	}
 }
+
+func Test_newAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Account
+		wantErr bool
+	}{
+		{"no role", "alice:hash", Account{"alice", "hash", RoleAdmin, nil, "", nil, 0}, false},
+		{"empty role", "alice:hash::", Account{"alice", "hash", RoleAdmin, nil, "", nil, 0}, false},
+		{"role", "alice:hash:viewer", Account{"alice", "hash", RoleViewer, nil, "", nil, 0}, false},
+		{"role and apps", "alice:hash:operator:1001,1002", Account{"alice", "hash", RoleOperator, []int{1001, 1002}, "", nil, 0}, false},
+		{"totp", "alice:hash:admin::SECRET:h1,h2", Account{"alice", "hash", RoleAdmin, nil, "SECRET", []string{"h1", "h2"}, 0}, false},
+		{"totp codes used up", "alice:hash:admin::SECRET:", Account{"alice", "hash", RoleAdmin, nil, "SECRET", nil, 0}, false},
+		{"totp step", "alice:hash:admin::SECRET:h1:57000000", Account{"alice", "hash", RoleAdmin, nil, "SECRET", []string{"h1"}, 57000000}, false},
+		{"bad totp step", "alice:hash:admin::SECRET:h1:x", Account{}, true},
+		{"bad role", "alice:hash:root", Account{}, true},
+		{"bad app", "alice:hash:viewer:x", Account{}, true},
+		{"no hash", "alice", Account{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newAccount(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newAccount() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newAccount() got = %v, want %v", got, tt.want)
+			}
+			if err == nil {
+				again, _ := newAccount(got.ShadowLine())
+				if !reflect.DeepEqual(again, got) {
+					t.Errorf("ShadowLine() round trip got = %v, want %v", again, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRole_Covers(t *testing.T) {
+	tests := []struct {
+		role Role
+		need Role
+		want bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleAdmin, RoleOperator, true},
+		{Role(""), RoleViewer, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role)+" on "+string(tt.need), func(t *testing.T) {
+			if got := tt.role.Covers(tt.need); got != tt.want {
+				t.Errorf("Covers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expireAt(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"fresh", created, created.Add(TokenIdleTimeout)},
+		{"slides", created.Add(time.Hour), created.Add(time.Hour + TokenIdleTimeout)},
+		{"capped", created.Add(TokenMaxLifetime - time.Minute), created.Add(TokenMaxLifetime)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expireAt(created, tt.now); !got.Equal(tt.want) {
+				t.Errorf("expireAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Tokens(t *testing.T) {
+	c, err := NewClient(nil, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := c.CreateToken("alice", "10.0.0.1")
+	ben, _ := c.CreateToken("ben", "10.0.0.2")
+	if got, ok := c.FindValidToken(alice.ID); !ok || got.Source != "10.0.0.1" {
+		t.Errorf("FindValidToken() = %v, %v", got, ok)
+	}
+	if ok, _ := c.RevokeToken(alice.ID); !ok {
+		t.Error("RevokeToken() not found")
+	}
+	if _, ok := c.FindValidToken(alice.ID); ok {
+		t.Error("FindValidToken() after revoke ok")
+	}
+	if got := c.Tokens(); len(got) != 1 || got[0].Hash != ben.Hash {
+		t.Errorf("Tokens() = %v, want only ben", got)
+	}
+	other, _ := c.CreateToken("ben", "10.0.0.3")
+	if got, _ := c.RevokeOtherTokens("ben", ben.ID); got != 1 {
+		t.Errorf("RevokeOtherTokens() = %v, want 1", got)
+	}
+	if _, ok := c.FindValidToken(other.ID); ok {
+		t.Error("FindValidToken() on other one after RevokeOtherTokens() ok")
+	}
+	if got, _ := c.Sweep(time.Now()); got != 0 {
+		t.Errorf("Sweep() now = %v, want 0", got)
+	}
+	if got, _ := c.Sweep(time.Now().Add(TokenIdleTimeout + time.Second)); got != 1 {
+		t.Errorf("Sweep() later = %v, want 1", got)
+	}
+	if got := c.Tokens(); len(got) != 0 {
+		t.Errorf("Tokens() after sweep = %v, want none", got)
+	}
+}
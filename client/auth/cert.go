@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// CertRule maps an identity in a client certificate to an account, whose role and apps apply.
+type CertRule struct {
+	Username string
+	Identity string // one of those CertIdentities returns, likes cn:ci-bot or dns:ci.example.com
+}
+
+// CertIdentities lists the subject common name and each SAN of a verified certificate, prefixed by its kind
+// as cn:, dns:, email:, uri: and ip:, so that a DNS name can not be taken as an email, etc.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ret []string
+	if cert.Subject.CommonName != "" {
+		ret = append(ret, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ret = append(ret, "dns:"+name)
+	}
+	for _, address := range cert.EmailAddresses {
+		ret = append(ret, "email:"+address)
+	}
+	for _, uri := range cert.URIs {
+		ret = append(ret, "uri:"+uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		ret = append(ret, "ip:"+ip.String())
+	}
+	return ret
+}
+
+// newCertRule parses a line as username:identity, the identity may have colons in itself.
+func newCertRule(line string) (CertRule, error) {
+	username, identity, found := strings.Cut(line, ":")
+	kind, value, _ := strings.Cut(identity, ":")
+	if !found || !usernamePattern.MatchString(username) || value == "" {
+		return CertRule{}, fmt.Errorf("bad cert rule line %s", line)
+	}
+	if !slices.Contains([]string{"cn", "dns", "email", "uri", "ip"}, kind) {
+		return CertRule{}, fmt.Errorf("bad cert rule line %s: unknown identity kind %s", line, kind)
+	}
+	return CertRule{
+		Username: username,
+		Identity: identity,
+	}, nil
+}
+
+func ParseCertRules(reader io.Reader) ([]CertRule, error) {
+	var ret []CertRule
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		one, err := newCertRule(line)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, one)
+	}
+	return ret, scanner.Err()
+}
+
+// LoadCertRules reads rules in path, a file not exist is taken as empty.
+func LoadCertRules(path string) ([]CertRule, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	ret, err := ParseCertRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse cert rule file %s: %v", path, err)
+	}
+	return ret, nil
+}
+
+func (c *Client) SetCertRules(rules []CertRule) {
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	c.certRules = slices.Clone(rules)
+}
+
+// FindAccountByCert returns the account of the first rule matching any of identities, with the identity matched.
+func (c *Client) FindAccountByCert(identities []string) (Account, string, bool) {
+	c.accountMu.RLock()
+	defer c.accountMu.RUnlock()
+	for _, rule := range c.certRules {
+		if slices.Contains(identities, rule.Identity) {
+			account, ok := c.usernameToAccount[rule.Username]
+			return account, rule.Identity, ok
+		}
+	}
+	return Account{}, "", false
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCertIdentities(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/ci")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "ci-bot"},
+		DNSNames:       []string{"ci.example.com"},
+		EmailAddresses: []string{"ci@example.com"},
+		URIs:           []*url.URL{uri},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	want := []string{"cn:ci-bot", "dns:ci.example.com", "email:ci@example.com", "uri:spiffe://example.com/ci", "ip:10.0.0.1"}
+	if got := CertIdentities(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("CertIdentities() = %v, want %v", got, want)
+	}
+}
+
+func TestParseCertRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []CertRule
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"rules", "# comment\nci:cn:ci-bot\n\ndeployer:uri:spiffe://example.com/deploy\n", []CertRule{
+			{"ci", "cn:ci-bot"},
+			{"deployer", "uri:spiffe://example.com/deploy"},
+		}, false},
+		{"no identity", "ci", nil, true},
+		{"empty identity", "ci:cn:", nil, true},
+		{"unknown kind", "ci:subject:ci-bot", nil, true},
+		{"bad username", "c i:cn:ci-bot", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCertRules(strings.NewReader(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCertRules() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCertRules() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_FindAccountByCert(t *testing.T) {
+	c, err := NewClient([]Account{
+		{Username: "ci", Role: RoleOperator},
+		{Username: "deployer", Role: RoleAdmin},
+	}, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCertRules([]CertRule{
+		{"ci", "cn:ci-bot"},
+		{"deployer", "dns:deploy.example.com"},
+		{"ghost", "cn:ghost"},
+	})
+	tests := []struct {
+		name       string
+		identities []string
+		want       string
+		wantID     string
+		wantOK     bool
+	}{
+		{"cn", []string{"cn:ci-bot"}, "ci", "cn:ci-bot", true},
+		{"san", []string{"cn:other", "dns:deploy.example.com"}, "deployer", "dns:deploy.example.com", true},
+		{"first rule wins", []string{"dns:deploy.example.com", "cn:ci-bot"}, "ci", "cn:ci-bot", true},
+		{"no account", []string{"cn:ghost"}, "", "cn:ghost", false},
+		{"no rule", []string{"dns:ci-bot"}, "", "", false},
+		{"nothing", nil, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, identity, ok := c.FindAccountByCert(tt.identities)
+			if ok != tt.wantOK || got.Username != tt.want || identity != tt.wantID {
+				t.Errorf("FindAccountByCert() = %v, %v, %v, want %v, %v, %v",
+					got.Username, identity, ok, tt.want, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes through a temporary file and rename, so that the file is never half written.
+// The file is only accessible by the owner, as what written here are credentials or their hash.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Nothing to remove after rename succeeded.
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Those formats of EncryptedPassword, detected by the prefix. All but bcrypt, the preferred one,
+// are to reuse hashes from other tools, and are rehashed in bcrypt on a successful Auth.
+const (
+	prefixSHA512Crypt = "$6$"        // as in /etc/shadow, $6$[rounds=N$]salt$hash
+	prefixArgon2id    = "$argon2id$" // PHC string, $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+	prefixScrypt      = "$scrypt$"   // PHC string, $scrypt$ln=15,r=8,p=1$salt$hash
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// EncryptPassword hashes in the preferred format.
+func EncryptPassword(password string) (string, error) {
+	ret, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(ret), err
+}
+
+// isPreferred tells whether the hash needs no rehash, which is any bcrypt in spite of its cost.
+func isPreferred(encryptedPassword string) bool {
+	_, err := bcrypt.Cost([]byte(encryptedPassword))
+	return err == nil
+}
+
+// comparePassword returns nil on match, bcrypt.ErrMismatchedHashAndPassword on mismatch, or any other error.
+func comparePassword(encryptedPassword string, password string) error {
+	var got, want []byte
+	var err error
+	switch {
+	case strings.HasPrefix(encryptedPassword, prefixSHA512Crypt):
+		got, want, err = compareSHA512Crypt(encryptedPassword, password)
+	case strings.HasPrefix(encryptedPassword, prefixArgon2id):
+		got, want, err = compareArgon2id(encryptedPassword, password)
+	case strings.HasPrefix(encryptedPassword, prefixScrypt):
+		got, want, err = compareScrypt(encryptedPassword, password)
+	case strings.HasPrefix(encryptedPassword, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(password))
+	default:
+		return ErrUnknownHash
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
+
+// phcParams parses those likes m=65536,t=3,p=4 in a PHC string.
+func phcParams(s string) (map[string]int, error) {
+	ret := make(map[string]int)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, found := strings.Cut(kv, "=")
+		if !found {
+			return nil, fmt.Errorf("bad param %s", kv)
+		}
+		num, err := strconv.Atoi(v)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("bad param %s", kv)
+		}
+		ret[k] = num
+	}
+	return ret, nil
+}
+
+// phcBase64 decodes salt and hash in a PHC string, which is standard base64 without padding.
+func phcBase64(salt string, hash string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad salt: %v", err)
+	}
+	h, err := base64.RawStdEncoding.DecodeString(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad hash: %v", err)
+	}
+	return s, h, nil
+}
+
+func compareArgon2id(encryptedPassword string, password string) (got []byte, want []byte, err error) {
+	// "", "argon2id", "v=19", params, salt, hash
+	parts := strings.Split(encryptedPassword, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return nil, nil, fmt.Errorf("%w: bad argon2id", ErrUnknownHash)
+	}
+	params, err := phcParams(parts[3])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: argon2id %v", ErrUnknownHash, err)
+	}
+	salt, want, err := phcBase64(parts[4], parts[5])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: argon2id %v", ErrUnknownHash, err)
+	}
+	m, t, p := params["m"], params["t"], params["p"]
+	if m == 0 || t == 0 || p == 0 || p > 255 {
+		return nil, nil, fmt.Errorf("%w: argon2id params %s", ErrUnknownHash, parts[3])
+	}
+	got = argon2.IDKey([]byte(password), salt, uint32(t), uint32(m), uint8(p), uint32(len(want)))
+	return got, want, nil
+}
+
+func compareScrypt(encryptedPassword string, password string) (got []byte, want []byte, err error) {
+	// "", "scrypt", params, salt, hash
+	parts := strings.Split(encryptedPassword, "$")
+	if len(parts) != 5 {
+		return nil, nil, fmt.Errorf("%w: bad scrypt", ErrUnknownHash)
+	}
+	params, err := phcParams(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: scrypt %v", ErrUnknownHash, err)
+	}
+	salt, want, err := phcBase64(parts[3], parts[4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: scrypt %v", ErrUnknownHash, err)
+	}
+	ln, r, p := params["ln"], params["r"], params["p"]
+	if ln == 0 || ln > 30 || r == 0 || p == 0 {
+		return nil, nil, fmt.Errorf("%w: scrypt params %s", ErrUnknownHash, parts[2])
+	}
+	got, err = scrypt.Key([]byte(password), salt, 1<<ln, r, p, len(want))
+	if err != nil {
+		return nil, nil, err
+	}
+	return got, want, nil
+}
+
+const (
+	sha512CryptDefaultRounds = 5000
+	sha512CryptMinRounds     = 1000
+	sha512CryptMaxRounds     = 999_999_999
+	sha512CryptMaxSalt       = 16
+)
+
+func compareSHA512Crypt(encryptedPassword string, password string) (got []byte, want []byte, err error) {
+	rest := strings.TrimPrefix(encryptedPassword, prefixSHA512Crypt)
+	i := strings.LastIndexByte(rest, '$')
+	if i < 0 {
+		return nil, nil, fmt.Errorf("%w: bad sha512-crypt", ErrUnknownHash)
+	}
+	setting := rest[:i]
+	rounds, custom := sha512CryptDefaultRounds, false
+	if str, ok := strings.CutPrefix(setting, "rounds="); ok {
+		num, salt, found := strings.Cut(str, "$")
+		n, err := strconv.Atoi(num)
+		if !found || err != nil {
+			return nil, nil, fmt.Errorf("%w: bad sha512-crypt rounds", ErrUnknownHash)
+		}
+		rounds, custom, setting = min(max(n, sha512CryptMinRounds), sha512CryptMaxRounds), true, salt
+	}
+	got = []byte(sha512Crypt([]byte(password), []byte(setting), rounds, custom))
+	return got, []byte(encryptedPassword), nil
+}
+
+// sha512CryptPermutation is the order of bytes in the final encoding, 3 bytes into 4 characters each.
+var sha512CryptPermutation = [21][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+const crypt64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha512Crypt is the SHA-512 one in Unix crypt by Ulrich Drepper, which /etc/shadow uses as $6$.
+func sha512Crypt(password []byte, salt []byte, rounds int, customRounds bool) string {
+	salt = salt[:min(len(salt), sha512CryptMaxSalt)]
+
+	b := sha512.New()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	sumB := b.Sum(nil)
+
+	a := sha512.New()
+	a.Write(password)
+	a.Write(salt)
+	n := len(password)
+	for ; n > sha512.Size; n -= sha512.Size {
+		a.Write(sumB)
+	}
+	a.Write(sumB[:n])
+	for n = len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(sumB)
+		} else {
+			a.Write(password)
+		}
+	}
+	sumA := a.Sum(nil)
+
+	dp := sha512.New()
+	for i := 0; i < len(password); i++ {
+		dp.Write(password)
+	}
+	p := repeatTo(dp.Sum(nil), len(password))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(sumA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatTo(ds.Sum(nil), len(salt))
+
+	c := sumA
+	for i := 0; i < rounds; i++ {
+		h := sha512.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefixSHA512Crypt)
+	if customRounds {
+		sb.WriteString(fmt.Sprintf("rounds=%d$", rounds))
+	}
+	sb.Write(salt)
+	sb.WriteByte('$')
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			sb.WriteByte(crypt64[w&0x3f])
+			w >>= 6
+		}
+	}
+	for _, j := range sha512CryptPermutation {
+		encode(c[j[0]], c[j[1]], c[j[2]], 4)
+	}
+	encode(0, 0, c[63], 2)
+	return sb.String()
+}
+
+func repeatTo(sum []byte, length int) []byte {
+	ret := make([]byte, 0, length)
+	for len(ret) < length {
+		ret = append(ret, sum[:min(len(sum), length-len(ret))]...)
+	}
+	return ret
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_comparePassword(t *testing.T) {
+	salt := []byte("saltsaltsaltsalt")
+	b64 := base64.RawStdEncoding.EncodeToString
+	argon2id := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		b64(salt), b64(argon2.IDKey([]byte("123456"), salt, 1, 1024, 1, 32)))
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		wantErr  error
+	}{
+		// Those sha512-crypt are from the specification by Ulrich Drepper.
+		{"sha512-crypt", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!", nil},
+		{"sha512-crypt rounds", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!", nil},
+		{"sha512-crypt mismatch", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world", bcrypt.ErrMismatchedHashAndPassword},
+		// scrypt.Key([]byte("password"), []byte("saltsalt"), 16, 8, 1, 32) as hashlib.scrypt in Python.
+		{"scrypt", "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$xdm4IMyPApeWQ+5AiPVw2L3OCnA4OBnnwWGIV2OM5+o", "password", nil},
+		{"scrypt mismatch", "$scrypt$ln=4,r=8,p=1$c2FsdHNhbHQ$xdm4IMyPApeWQ+5AiPVw2L3OCnA4OBnnwWGIV2OM5+o", "passw0rd", bcrypt.ErrMismatchedHashAndPassword},
+		{"argon2id", argon2id, "123456", nil},
+		{"argon2id mismatch", argon2id, "654321", bcrypt.ErrMismatchedHashAndPassword},
+		{"bcrypt", string(bcryptHash), "123456", nil},
+		{"bcrypt mismatch", string(bcryptHash), "654321", bcrypt.ErrMismatchedHashAndPassword},
+		{"md5-crypt", "$1$salt$hash", "123456", ErrUnknownHash},
+		{"bad argon2id", "$argon2id$v=19$m=1024$x$y", "123456", ErrUnknownHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := comparePassword(tt.hash, tt.password); !errors.Is(err, tt.wantErr) {
+				t.Errorf("comparePassword() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_AuthRehash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow")
+	line := "alice:$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1:viewer:\n"
+	if err := os.WriteFile(path, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Auth("alice", "wrong"); err != nil || ok {
+		t.Fatalf("Auth() wrong = %v, %v", ok, err)
+	}
+	if account, _ := c.FindAccount("alice"); !strings.HasPrefix(account.EncryptedPassword, "$6$") {
+		t.Errorf("rehashed on mismatch to %v", account.EncryptedPassword)
+	}
+	if ok, err := c.Auth("alice", "Hello world!"); err != nil || !ok {
+		t.Fatalf("Auth() = %v, %v", ok, err)
+	}
+
+	reloaded, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, _ := reloaded.FindAccount("alice")
+	if !isPreferred(account.EncryptedPassword) || account.Role != RoleViewer {
+		t.Errorf("saved account = %v, want rehashed in bcrypt", account)
+	}
+	if ok, err := reloaded.Auth("alice", "Hello world!"); err != nil || !ok {
+		t.Errorf("Auth() after rehash = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// lockoutSweepSize is how many keys Lockout keeps before it sweeps the stale ones,
+// so that guesses on random usernames can not exhaust memory.
+const lockoutSweepSize = 1024
+
+// Lockout locks a key, likes a username or an IP, after Threshold failures in a row.
+// Each failure more doubles the lock duration from Base up to Max.
+type Lockout struct {
+	threshold  int // zero means never lock
+	base       time.Duration
+	max        time.Duration
+	mu         sync.Mutex
+	keyToState map[string]*lockState
+	onLock     func(Lock) // nullable, see OnLock
+}
+
+// Lock is a key locked out by a failed login on username from source.
+type Lock struct {
+	Username string
+	Source   string
+	Key      string
+	Until    time.Time
+	Time     time.Time
+}
+
+type lockState struct {
+	failures    int
+	inFlight    int // attempts acquired but not ended
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// AcquireRetry is when to retry an attempt refused for those in flight, which end in a bcrypt or so.
+const AcquireRetry = time.Second
+
+func NewLockout(threshold int, base time.Duration, max time.Duration) *Lockout {
+	return &Lockout{
+		threshold:  threshold,
+		base:       base,
+		max:        max,
+		mu:         sync.Mutex{},
+		keyToState: make(map[string]*lockState),
+		onLock:     nil,
+	}
+}
+
+// OnLock tells whom to record each Lock by FailLogin, wherever the login is, likes the control plane or the gateway.
+// It's called out of lock, and shall be set before any login.
+func (l *Lockout) OnLock(f func(Lock)) {
+	l.onLock = f
+}
+
+// loginKeys are those a login counts on, so that guesses on an account from many IPs and on many accounts
+// from one IP are both locked.
+func loginKeys(username string, source string) []string {
+	return []string{"user:" + username, "ip:" + source}
+}
+
+// AcquireLogin acquires an attempt on both the username and the source, as Acquire, which shall end in
+// FailLogin, SucceedLogin or ReleaseLogin. It returns the key refused with when to retry.
+func (l *Lockout) AcquireLogin(username string, source string, now time.Time) (key string, retryAt time.Time, ok bool) {
+	keys := loginKeys(username, source)
+	for i, key := range keys {
+		if retryAt, ok := l.Acquire(key, now); !ok {
+			for _, acquired := range keys[:i] {
+				l.Release(acquired)
+			}
+			return key, retryAt, false
+		}
+	}
+	return "", time.Time{}, true
+}
+
+// FailLogin counts the failure on both the username and the source, and records those locked by it.
+func (l *Lockout) FailLogin(username string, source string, now time.Time) {
+	for _, key := range loginKeys(username, source) {
+		until, locked := l.Fail(key, now)
+		if locked && l.onLock != nil {
+			l.onLock(Lock{Username: username, Source: source, Key: key, Until: until, Time: now})
+		}
+	}
+}
+
+// SucceedLogin forgets failures on the username only. Those on the source expire by time, as one could
+// login on an account of their own between guesses on others otherwise.
+func (l *Lockout) SucceedLogin(username string, source string) {
+	keys := loginKeys(username, source)
+	l.Succeed(keys[0])
+	l.Release(keys[1])
+}
+
+// ReleaseLogin ends the attempt which is neither a failure nor a success, likes on an internal error.
+func (l *Lockout) ReleaseLogin(username string, source string) {
+	for _, key := range loginKeys(username, source) {
+		l.Release(key)
+	}
+}
+
+// stale tells whether the failures have been forgotten, which is after max since the last one and not locked.
+func (l *Lockout) stale(state *lockState, now time.Time) bool {
+	return state.inFlight == 0 && now.After(state.lockedUntil) && now.Sub(state.lastFailure) > l.max
+}
+
+// LockedUntil returns when the lock on key ends, and false if not locked.
+func (l *Lockout) LockedUntil(key string, now time.Time) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.keyToState[key]
+	if !ok || !now.Before(state.lockedUntil) {
+		return time.Time{}, false
+	}
+	return state.lockedUntil, true
+}
+
+// Acquire reserves an attempt on key, which shall end in Fail, Succeed or Release.
+// It returns false with when to retry if the key is locked, or if the failures with those in flight would reach
+// the threshold, so that parallel guesses could not pass the check all at once.
+func (l *Lockout) Acquire(key string, now time.Time) (retryAt time.Time, ok bool) {
+	if l.threshold <= 0 {
+		return time.Time{}, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.state(key, now)
+	if now.Before(state.lockedUntil) {
+		return state.lockedUntil, false
+	}
+	if state.failures+state.inFlight >= l.threshold {
+		return now.Add(AcquireRetry), false
+	}
+	state.inFlight++
+	return time.Time{}, true
+}
+
+// Release ends an attempt acquired which is neither a failure nor a success, likes on an internal error.
+func (l *Lockout) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state, ok := l.keyToState[key]; ok && state.inFlight > 0 {
+		state.inFlight--
+	}
+}
+
+// state returns the one on key, which is new if stale, and sweeps stale ones if too many.
+// It shall be called with mu locked.
+func (l *Lockout) state(key string, now time.Time) *lockState {
+	if len(l.keyToState) >= lockoutSweepSize {
+		for k, state := range l.keyToState {
+			if l.stale(state, now) {
+				delete(l.keyToState, k)
+			}
+		}
+	}
+	state, ok := l.keyToState[key]
+	if !ok || l.stale(state, now) {
+		state = &lockState{}
+		l.keyToState[key] = state
+	}
+	return state
+}
+
+// Fail counts a failure on key, and ends an attempt acquired if any.
+// It returns when the lock ends if it's locked by this one.
+func (l *Lockout) Fail(key string, now time.Time) (lockedUntil time.Time, locked bool) {
+	if l.threshold <= 0 {
+		return time.Time{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := l.state(key, now)
+	if state.inFlight > 0 {
+		state.inFlight--
+	}
+	state.failures++
+	state.lastFailure = now
+	if state.failures < l.threshold {
+		return time.Time{}, false
+	}
+	state.lockedUntil = now.Add(l.duration(state.failures - l.threshold))
+	return state.lockedUntil, true
+}
+
+// duration is Base doubled n times, up to Max.
+func (l *Lockout) duration(n int) time.Duration {
+	ret := l.base
+	for i := 0; i < n && ret < l.max; i++ {
+		ret *= 2
+	}
+	return min(ret, l.max)
+}
+
+// Succeed forgets failures on key, and ends an attempt acquired if any.
+func (l *Lockout) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, ok := l.keyToState[key]
+	if !ok {
+		return
+	}
+	if state.inFlight > 0 {
+		state.inFlight--
+	}
+	if state.inFlight == 0 {
+		delete(l.keyToState, key)
+		return
+	}
+	state.failures, state.lockedUntil = 0, time.Time{}
+}
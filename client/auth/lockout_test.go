@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLockout(3, time.Second, 4*time.Second)
+	tests := []struct {
+		name       string
+		offset     time.Duration // since now
+		fail       bool          // or succeed
+		wantLocked bool
+		wantUntil  time.Duration // since now
+	}{
+		{"first", 0, true, false, 0},
+		{"second", 0, true, false, 0},
+		{"third locks", 0, true, true, time.Second},
+		{"fourth doubles", 2 * time.Second, true, true, 4 * time.Second},
+		{"fifth doubles", 5 * time.Second, true, true, 9 * time.Second},
+		{"sixth capped", 9 * time.Second, true, true, 13 * time.Second},
+		{"forgotten after max", 20 * time.Second, true, false, 0},
+		{"succeed resets", 20 * time.Second, false, false, 0},
+		{"first again", 20 * time.Second, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := now.Add(tt.offset)
+			if !tt.fail {
+				l.Succeed("alice")
+				return
+			}
+			until, locked := l.Fail("alice", at)
+			if locked != tt.wantLocked || (locked && !until.Equal(now.Add(tt.wantUntil))) {
+				t.Errorf("Fail() = %v, %v, want locked %v until %v", until, locked, tt.wantLocked, now.Add(tt.wantUntil))
+			}
+			if _, got := l.LockedUntil("alice", at); got != tt.wantLocked {
+				t.Errorf("LockedUntil() locked = %v, want %v", got, tt.wantLocked)
+			}
+			if _, got := l.LockedUntil("ben", at); got {
+				t.Error("LockedUntil() on another key locked")
+			}
+		})
+	}
+}
+
+func TestLockout_Disabled(t *testing.T) {
+	l := NewLockout(0, time.Second, time.Minute)
+	for i := 0; i < 10; i++ {
+		if _, locked := l.Fail("alice", time.Now()); locked {
+			t.Fatal("Fail() locked with threshold 0")
+		}
+	}
+}
+
+func TestLockout_Acquire(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLockout(3, time.Second, time.Minute)
+	if _, ok := l.Acquire("alice", now); !ok {
+		t.Fatal("Acquire() first not ok")
+	}
+	l.Fail("alice", now)
+	// Two more in flight reach the threshold with the failure, which could all be wrong.
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Acquire("alice", now); !ok {
+			t.Fatalf("Acquire() in flight %d not ok", i)
+		}
+	}
+	if retryAt, ok := l.Acquire("alice", now); ok || !retryAt.Equal(now.Add(AcquireRetry)) {
+		t.Errorf("Acquire() over threshold = %v, %v, want retry at %v", retryAt, ok, now.Add(AcquireRetry))
+	}
+	l.Release("alice")
+	if _, ok := l.Acquire("alice", now); !ok {
+		t.Error("Acquire() after release not ok")
+	}
+	l.Fail("alice", now)
+	until, locked := l.Fail("alice", now)
+	if !locked {
+		t.Fatal("Fail() in flight not locked")
+	}
+	if retryAt, ok := l.Acquire("alice", now); ok || !retryAt.Equal(until) {
+		t.Errorf("Acquire() locked = %v, %v, want retry at %v", retryAt, ok, until)
+	}
+	l.Succeed("alice")
+	if _, ok := l.Acquire("alice", now); !ok {
+		t.Error("Acquire() after succeed not ok")
+	}
+}
+
+func TestLockout_SucceedLogin(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLockout(3, time.Second, time.Minute)
+	var locks []Lock
+	l.OnLock(func(lock Lock) {
+		locks = append(locks, lock)
+	})
+	for _, tt := range []struct {
+		username string
+		succeed  bool
+	}{
+		{"alice", false},
+		{"bob", false},
+		{"eve", true}, // the one of their own
+		{"carol", false},
+	} {
+		if _, _, ok := l.AcquireLogin(tt.username, "192.0.2.1", now); !ok {
+			t.Fatalf("AcquireLogin() %s not ok", tt.username)
+		}
+		if tt.succeed {
+			l.SucceedLogin(tt.username, "192.0.2.1")
+		} else {
+			l.FailLogin(tt.username, "192.0.2.1", now)
+		}
+	}
+	if len(locks) != 1 || locks[0].Key != "ip:192.0.2.1" {
+		t.Errorf("locks = %v, want one on the IP", locks)
+	}
+	if key, _, ok := l.AcquireLogin("dave", "192.0.2.1", now); ok || key != "ip:192.0.2.1" {
+		t.Errorf("AcquireLogin() = %v, %v, want refused on the IP", key, ok)
+	}
+	if _, _, ok := l.AcquireLogin("eve", "198.51.100.1", now); !ok {
+		t.Error("AcquireLogin() from another IP not ok")
+	}
+}
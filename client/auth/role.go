@@ -0,0 +1,31 @@
+package auth
+
+import "fmt"
+
+// Role is what an account could do, each one covers those before it.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"   // read only
+	RoleOperator Role = "operator" // start and interact with apps
+	RoleAdmin    Role = "admin"    // everything, including kill any process on host
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if _, ok := roleRanks[r]; !ok {
+		return "", fmt.Errorf("unknown role %s", s)
+	}
+	return r, nil
+}
+
+// Covers tells whether r could do what need could.
+func (r Role) Covers(need Role) bool {
+	return roleRanks[r] >= roleRanks[need]
+}
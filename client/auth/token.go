@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// TokenStore keeps tokens on Token.Hash, each method of which is atomic.
+type TokenStore interface {
+	Put(t Token) error
+	// Update replaces the token on hash by what f returns, or removes it if f returns false.
+	// It returns false if not found.
+	Update(hash string, f func(Token) (Token, bool)) (Token, bool)
+	// Delete returns false if not found.
+	Delete(hash string) (bool, error)
+	// DeleteFunc removes those del returns true, and returns how many are removed.
+	DeleteFunc(del func(Token) bool) (int, error)
+	List() []Token
+}
+
+// MemoryTokenStore keeps tokens in memory, which vanish on restart.
+type MemoryTokenStore struct {
+	mu          sync.Mutex
+	hashToToken map[string]Token
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		mu:          sync.Mutex{},
+		hashToToken: make(map[string]Token),
+	}
+}
+
+func (s *MemoryTokenStore) Put(t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashToToken[t.Hash] = t
+	return nil
+}
+
+func (s *MemoryTokenStore) Update(hash string, f func(Token) (Token, bool)) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret, _, ok := s.update(hash, f)
+	return ret, ok
+}
+
+// update shall be called with mu locked, found tells whether the token exists before, even if removed by f.
+func (s *MemoryTokenStore) update(hash string, f func(Token) (Token, bool)) (ret Token, found bool, ok bool) {
+	t, found := s.hashToToken[hash]
+	if !found {
+		return Token{}, false, false
+	}
+	t, keep := f(t)
+	if !keep {
+		delete(s.hashToToken, hash)
+		return Token{}, true, false
+	}
+	s.hashToToken[hash] = t
+	return t, true, true
+}
+
+func (s *MemoryTokenStore) Delete(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.hashToToken[hash]
+	delete(s.hashToToken, hash)
+	return ok, nil
+}
+
+func (s *MemoryTokenStore) DeleteFunc(del func(Token) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteFunc(del), nil
+}
+
+// deleteFunc shall be called with mu locked.
+func (s *MemoryTokenStore) deleteFunc(del func(Token) bool) int {
+	count := 0
+	for hash, t := range s.hashToToken {
+		if del(t) {
+			delete(s.hashToToken, hash)
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryTokenStore) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *MemoryTokenStore) list() []Token {
+	ret := make([]Token, 0, len(s.hashToToken))
+	for _, t := range s.hashToToken {
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// FileTokenStore keeps tokens in memory and a JSON file, so that sessions survive restarts.
+// The file has no token ID but its hash, so that anyone reads it could not take over the sessions.
+// The file is rewritten on Put, Delete and DeleteFunc. An Update, which happens on each use of a token,
+// is only written with the next one of them, likes the sweep by Client.RunSweeper.
+type FileTokenStore struct {
+	MemoryTokenStore
+	path  string
+	dirty bool // some Update not written
+}
+
+// OpenFileTokenStore loads tokens in path, a file not exist is taken as empty.
+func OpenFileTokenStore(path string) (*FileTokenStore, error) {
+	ret := &FileTokenStore{
+		MemoryTokenStore: MemoryTokenStore{
+			mu:          sync.Mutex{},
+			hashToToken: make(map[string]Token),
+		},
+		path:  path,
+		dirty: false,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []struct {
+		Token
+		ID string // only in a file before Token.Hash, which is hashed and rewritten here
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse token file %s: %v", path, err)
+	}
+	plain := false
+	for _, t := range tokens {
+		if t.ID != "" {
+			t.Token.Hash, plain = hashKey(t.ID), true
+		}
+		ret.hashToToken[t.Token.Hash] = t.Token
+	}
+	if plain {
+		if err := ret.save(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// save shall be called with mu locked.
+func (s *FileTokenStore) save() error {
+	data, err := json.Marshal(s.list())
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		s.dirty = true
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *FileTokenStore) Put(t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashToToken[t.Hash] = t
+	return s.save()
+}
+
+func (s *FileTokenStore) Update(hash string, f func(Token) (Token, bool)) (Token, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret, found, ok := s.update(hash, f)
+	if found {
+		s.dirty = true
+	}
+	return ret, ok
+}
+
+func (s *FileTokenStore) Delete(hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.hashToToken[hash]
+	if !ok {
+		return false, nil
+	}
+	delete(s.hashToToken, hash)
+	return true, s.save()
+}
+
+func (s *FileTokenStore) DeleteFunc(del func(Token) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := s.deleteFunc(del)
+	if count == 0 && !s.dirty {
+		return 0, nil
+	}
+	return count, s.save()
+}
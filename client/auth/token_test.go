@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"amah/client/audit"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestFileTokenStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	store, err := OpenFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewClient(nil, store)
+	alice, err := c.CreateToken("alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ben, _ := c.CreateToken("ben", "10.0.0.2")
+	if _, err := c.RevokeToken(ben.ID); err != nil {
+		t.Fatal(err)
+	}
+	used, _ := c.FindValidToken(alice.ID)
+	// The Update on use is written by the next sweep.
+	if _, err := c.Sweep(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), alice.ID) {
+		t.Errorf("token file %s has the token ID", data)
+	}
+
+	reopened, err := OpenFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.List(); len(got) != 1 || !got[0].LastUsedAt.Equal(used.LastUsedAt) {
+		t.Errorf("List() after reopen = %v, want alice used at %v", got, used.LastUsedAt)
+	}
+	c, _ = NewClient(nil, reopened)
+	got, ok := c.FindValidToken(alice.ID)
+	if !ok || got.Username != "alice" || got.Source != "10.0.0.1" {
+		t.Errorf("FindValidToken() after reopen = %v, %v", got, ok)
+	}
+	if _, ok := c.FindValidToken(ben.ID); ok {
+		t.Error("FindValidToken() on revoked after reopen ok")
+	}
+}
+
+func TestOpenFileTokenStore_plain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	const id = "5f0f6b4e-7f5c-4a53-9d3c-2b1b5b7c9f10"
+	expireAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	plain := `[{"ID":"` + id + `","ExpireAt":"` + expireAt + `","Username":"alice"}]`
+	if err := os.WriteFile(path, []byte(plain), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenFileTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), id) {
+		t.Errorf("token file %s not rewritten without the ID", data)
+	}
+	c, _ := NewClient(nil, store)
+	got, ok := c.FindValidToken(id)
+	if !ok || got.Username != "alice" {
+		t.Errorf("FindValidToken() on plain one = %v, %v", got, ok)
+	}
+	if got, want := audit.DigestTokenHash(got.Hash), audit.DigestToken(id); got != want {
+		t.Errorf("DigestTokenHash() = %v, want DigestToken() %v", got, want)
+	}
+}
+
+// TestClient_Concurrent shall run with -race, which hammers login and validation in parallel.
+func TestClient_Concurrent(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("123456"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := []Account{{Username: "alice", EncryptedPassword: string(hash), Role: RoleAdmin}}
+	fileStore, err := OpenFileTokenStore(filepath.Join(t.TempDir(), "tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := []struct {
+		name  string
+		store TokenStore
+	}{
+		{"memory", NewMemoryTokenStore()},
+		{"file", fileStore},
+	}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := NewClient(accounts, tt.store)
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						if ok, err := c.Auth("alice", "123456"); err != nil || !ok {
+							t.Errorf("Auth() = %v, %v", ok, err)
+							return
+						}
+						token, err := c.CreateToken("alice", "127.0.0.1")
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if _, ok := c.FindValidToken(token.ID); !ok {
+							t.Errorf("FindValidToken() on fresh %s not ok", token.ID)
+							return
+						}
+						if j%2 == 0 {
+							_, _ = c.RevokeToken(token.ID)
+						}
+						_ = c.Tokens()
+					}
+				}()
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					_, _ = c.Sweep(time.Now())
+				}
+			}()
+			wg.Wait()
+			if got := len(c.Tokens()); got != 8*25 {
+				t.Errorf("Tokens() len = %v, want %v", got, 8*25)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TOTP as RFC 6238 in the parameters that most authenticator apps only support.
+const (
+	totpPeriod = 30 // seconds
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted before or after, for clock drift
+)
+
+const TOTPIssuer = "amah"
+
+const recoveryCodeCount = 10
+
+var ErrNoTOTP = errors.New("totp not enrolled")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode is HOTP of RFC 4226 on the step.
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// matchTOTP returns the step that the code matches around now, or false.
+func matchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hashRecoveryCode is sha256 without salt, which is as strong as the TOTP secret kept in plain next to it.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Enrollment is what an account owner needs to set up TOTP, which is shown only once.
+type Enrollment struct {
+	Secret        string
+	URI           string // otpauth URI, likes a QR code shows
+	RecoveryCodes []string
+}
+
+func NewEnrollment(username string) (Enrollment, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return Enrollment{}, err
+	}
+	secret := totpEncoding.EncodeToString(key)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + TOTPIssuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		data := make([]byte, 5)
+		if _, err := rand.Read(data); err != nil {
+			return Enrollment{}, err
+		}
+		code := totpEncoding.EncodeToString(data)
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return Enrollment{
+		Secret:        secret,
+		URI:           uri.String(),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// EnrollTOTP sets up TOTP on the account, replacing the old one if any.
+func (c *Client) EnrollTOTP(username string) (Enrollment, error) {
+	enrollment, err := NewEnrollment(username)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok {
+		return Enrollment{}, fmt.Errorf("no account %s", username)
+	}
+	account.TOTPSecret = enrollment.Secret
+	account.TOTPStep = 0
+	account.RecoveryCodes = nil
+	for _, code := range enrollment.RecoveryCodes {
+		account.RecoveryCodes = append(account.RecoveryCodes, hashRecoveryCode(code))
+	}
+	if err := c.updateAccount(account); err != nil {
+		return Enrollment{}, err
+	}
+	return enrollment, nil
+}
+
+// VerifyOTP checks the code as TOTP, or a recovery code which is consumed then.
+// A TOTP code can not be used twice, so is any earlier one, as the step used is saved with the account.
+func (c *Client) VerifyOTP(username string, otp string) (bool, error) {
+	c.accountMu.Lock()
+	defer c.accountMu.Unlock()
+	account, ok := c.usernameToAccount[username]
+	if !ok || account.TOTPSecret == "" {
+		return false, ErrNoTOTP
+	}
+	if step, ok := matchTOTP(account.TOTPSecret, otp, time.Now()); ok {
+		if step <= account.TOTPStep {
+			return false, nil
+		}
+		account.TOTPStep = step
+		if err := c.updateAccount(account); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	hash := hashRecoveryCode(otp)
+	i := slices.Index(account.RecoveryCodes, hash)
+	if i < 0 {
+		return false, nil
+	}
+	account.RecoveryCodes = slices.Delete(slices.Clone(account.RecoveryCodes), i, i+1)
+	if err := c.updateAccount(account); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_totpCode(t *testing.T) {
+	// Those in RFC 6238 Appendix B on SHA1, in 6 digits.
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+				t.Errorf("totpCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_VerifyOTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(path, []byte("alice:hash:admin:\nben:hash:viewer:\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyOTP("alice", "000000"); err != ErrNoTOTP {
+		t.Errorf("VerifyOTP() before enroll err = %v, want %v", err, ErrNoTOTP)
+	}
+	enrollment, err := c.EnrollTOTP("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/amah:alice?") {
+		t.Errorf("URI = %v", enrollment.URI)
+	}
+
+	key, _ := totpEncoding.DecodeString(enrollment.Secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if ok, err := c.VerifyOTP("alice", code); err != nil || !ok {
+		t.Errorf("VerifyOTP() = %v, %v, want ok", ok, err)
+	}
+	if ok, _ := c.VerifyOTP("alice", code); ok {
+		t.Error("VerifyOTP() replayed ok")
+	}
+	recovery := enrollment.RecoveryCodes[0]
+	if ok, err := c.VerifyOTP("alice", recovery); err != nil || !ok {
+		t.Errorf("VerifyOTP() on recovery code = %v, %v, want ok", ok, err)
+	}
+	if ok, _ := c.VerifyOTP("alice", recovery); ok {
+		t.Error("VerifyOTP() on used recovery code ok")
+	}
+
+	reloaded, err := LoadClient(path, NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, _ := reloaded.FindAccount("alice")
+	if account.TOTPSecret != enrollment.Secret || len(account.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("saved account = %v, want secret and %d recovery codes", account, recoveryCodeCount-1)
+	}
+	if ok, _ := reloaded.VerifyOTP("alice", recovery); ok {
+		t.Error("VerifyOTP() on used recovery code after reload ok")
+	}
+	if ok, _ := reloaded.VerifyOTP("alice", code); ok {
+		t.Error("VerifyOTP() replayed after reload ok")
+	}
+	if account, _ := reloaded.FindAccount("ben"); account.Role != RoleViewer {
+		t.Errorf("saved ben = %v", account)
+	}
+}
@@ -0,0 +1,147 @@
+package notifier
+
+import (
+	"amah/client/application"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const DefaultEmailSubject = `[amah] {{len .Events}} event(s){{with index .Events 0}}, first {{.Type}} on app {{.AppID}}{{end}}`
+const DefaultEmailBody = `{{range .Events}}{{.Time.Format "2006-01-02 15:04:05"}} app {{.AppID}} {{.Type}} {{.Message}}
+{{with .Alert}}{{range .Context}}    {{.}}
+{{end}}{{end}}{{end}}`
+
+// Email sends events as mails through SMTP, with STARTTLS once the server supports.
+type Email struct {
+	Addr       string // host:port of the SMTP server
+	Username   string // empty means no auth
+	Password   string
+	RequireTLS bool `yaml:"requireTLS"` // refuse to send if the server does not support STARTTLS
+	From       string
+	To         []string
+	Subject    string                  // text/template on Digest, empty means DefaultEmailSubject
+	Body       string                  // text/template on Digest, empty means DefaultEmailBody
+	Events     []application.EventType // which types to send, empty means all
+	// Window batches events since the first one in it into one mail as a digest, zero means one mail per event.
+	Window time.Duration
+}
+
+// Digest is what templates of Email are executed on.
+type Digest struct {
+	Events []application.Event
+}
+
+type emailSender struct {
+	Email
+	host    string
+	subject *template.Template
+	body    *template.Template
+}
+
+func newEmailSender(email Email) (*emailSender, error) {
+	host, _, err := net.SplitHostPort(email.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(email.To) == 0 {
+		return nil, errors.New("no recipient")
+	}
+	if email.Subject == "" {
+		email.Subject = DefaultEmailSubject
+	}
+	if email.Body == "" {
+		email.Body = DefaultEmailBody
+	}
+	subject, err := template.New("subject").Parse(email.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("bad subject template: %w", err)
+	}
+	body, err := template.New("body").Parse(email.Body)
+	if err != nil {
+		return nil, fmt.Errorf("bad body template: %w", err)
+	}
+	return &emailSender{
+		Email:   email,
+		host:    host,
+		subject: subject,
+		body:    body,
+	}, nil
+}
+
+func (s *emailSender) send(events []application.Event) error {
+	msg, err := s.compose(Digest{Events: events}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.Dial(s.Addr)
+	if err != nil {
+		return err
+	}
+	defer func(c *smtp.Client) {
+		_ = c.Close()
+	}(c)
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	} else if s.RequireTLS {
+		return fmt.Errorf("no STARTTLS on %s", s.Addr)
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost.
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *emailSender) compose(digest Digest, now time.Time) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, digest); err != nil {
+		return nil, err
+	}
+	if err := s.body.Execute(&body, digest); err != nil {
+		return nil, err
+	}
+	var ret bytes.Buffer
+	header := func(k, v string) {
+		ret.WriteString(k + ": " + v + "\r\n")
+	}
+	header("From", s.From)
+	header("To", strings.Join(s.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	ret.WriteString("\r\n")
+	ret.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return ret.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package notifier
+
+import (
+	"amah/client/application"
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one mail each connection without TLS, and sends the DATA to mails.
+func fakeSMTP(t *testing.T, mails chan<- string) (addr string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, mails)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveSMTP(conn net.Conn, mails chan<- string) {
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+	r := bufio.NewReader(conn)
+	reply := func(s string) {
+		_, _ = conn.Write([]byte(s + "\r\n"))
+	}
+	reply("220 fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var sb strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				sb.WriteString(l)
+			}
+			mails <- sb.String()
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestClient_NotifyEmail(t *testing.T) {
+	mails := make(chan string, 10)
+	c, err := NewClient(Config{Emails: []Email{{
+		Addr:     fakeSMTP(t, mails),
+		Username: "amah",
+		Password: "secret",
+		From:     "amah@example.com",
+		To:       []string{"ops@example.com"},
+		Subject:  "{{len .Events}} events",
+		Events:   []application.EventType{application.EventCrash, application.EventRestart},
+		Window:   100 * time.Millisecond,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Notify(application.Event{AppID: 1, Type: application.EventCrash, Message: "signal: killed"})
+	c.Notify(application.Event{AppID: 1, Type: application.EventStart})
+	c.Notify(application.Event{AppID: 1, Type: application.EventRestart})
+
+	select {
+	case mail := <-mails:
+		for _, want := range []string{"Subject: 2 events", "To: ops@example.com", "app 1 crash signal: killed", "app 1 restart"} {
+			if !strings.Contains(mail, want) {
+				t.Errorf("mail has no %q in\n%s", want, mail)
+			}
+		}
+		if strings.Contains(mail, "app 1 start") {
+			t.Errorf("mail has the filtered start in\n%s", mail)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("received nothing")
+	}
+}
+
+func TestEmailSender_RequireTLS(t *testing.T) {
+	mails := make(chan string, 1)
+	s, err := newEmailSender(Email{
+		Addr:       fakeSMTP(t, mails),
+		RequireTLS: true,
+		From:       "amah@example.com",
+		To:         []string{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send([]application.Event{{Type: application.EventCrash}}); err == nil {
+		t.Error("send() err = nil, want no STARTTLS")
+	}
+}
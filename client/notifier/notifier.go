@@ -0,0 +1,164 @@
+package notifier
+
+import (
+	"amah/client/application"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Webhooks []Webhook
+	Emails   []Email
+}
+
+// LoadConfig reads the config file in YAML, an empty path means no notification at all.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	var ret Config
+	if err := yaml.NewDecoder(file).Decode(&ret); err != nil {
+		return Config{}, err
+	}
+	return ret, nil
+}
+
+// Client sends notifications on events to every destination in Config.
+// Each destination has its own queue, so that a slow one never delays the others.
+type Client struct {
+	destinations []*destination
+	urlToWebhook map[string]*destination // of those in destinations
+	mu           sync.Mutex
+	urlToAdhoc   map[string]*destination // webhooks not in Config, guarded by mu
+}
+
+// sender delivers events to a destination, with its own retry.
+type sender interface {
+	send(events []application.Event) error
+}
+
+type destination struct {
+	name   string
+	sender sender
+	filter map[application.EventType]bool // empty means all
+	window time.Duration                  // how long to batch events into one send, zero means no batch
+	queue  chan application.Event
+}
+
+// queueLength is how many events could wait for a slow destination, more are dropped.
+const queueLength = 100
+
+func NewClient(config Config) (*Client, error) {
+	ret := &Client{
+		destinations: nil,
+		urlToWebhook: make(map[string]*destination),
+		mu:           sync.Mutex{},
+		urlToAdhoc:   make(map[string]*destination),
+	}
+	for _, webhook := range config.Webhooks {
+		ret.urlToWebhook[webhook.URL] = ret.add("webhook "+webhook.URL, newWebhookSender(webhook), webhook.Events, 0)
+	}
+	for _, email := range config.Emails {
+		s, err := newEmailSender(email)
+		if err != nil {
+			return nil, fmt.Errorf("email to %v: %w", email.To, err)
+		}
+		ret.add(fmt.Sprintf("email %v", email.To), s, email.Events, email.Window)
+	}
+	return ret, nil
+}
+
+func (c *Client) add(name string, s sender, types []application.EventType, window time.Duration) *destination {
+	d := newDestination(name, s, types, window)
+	c.destinations = append(c.destinations, d)
+	return d
+}
+
+func newDestination(name string, s sender, types []application.EventType, window time.Duration) *destination {
+	d := &destination{
+		name:   name,
+		sender: s,
+		filter: make(map[application.EventType]bool),
+		window: window,
+		queue:  make(chan application.Event, queueLength),
+	}
+	for _, t := range types {
+		d.filter[t] = true
+	}
+	go d.run()
+	return d
+}
+
+// run sends events in queue, on window it waits for more since the first one and sends them as a digest.
+func (d *destination) run() {
+	for e := range d.queue {
+		batch := []application.Event{e}
+		if d.window > 0 {
+			timeout := time.After(d.window)
+		collect:
+			for {
+				select {
+				case more := <-d.queue:
+					batch = append(batch, more)
+				case <-timeout:
+					break collect
+				}
+			}
+		}
+		if err := d.sender.send(batch); err != nil {
+			slog.Error("notify", "to", d.name, "count", len(batch), "first", e.Type, "appID", e.AppID, "err", err)
+		}
+	}
+}
+
+// Notify queues the event to those destinations interested in, it never blocks.
+func (c *Client) Notify(e application.Event) {
+	for _, d := range c.destinations {
+		if d.accepts(e.Type) {
+			d.enqueue(e)
+		}
+	}
+}
+
+// NotifyWebhook queues the event to the webhook on url only, likes the one of an alert rule, it never blocks.
+// A webhook in Config on url sends it with its headers, secret and retries, unless it's interested in the event,
+// which Notify has sent. Any other url is sent with the defaults and no signature.
+func (c *Client) NotifyWebhook(url string, e application.Event) {
+	if d, ok := c.urlToWebhook[url]; ok {
+		if !d.accepts(e.Type) {
+			d.enqueue(e)
+		}
+		return
+	}
+	c.mu.Lock()
+	d, ok := c.urlToAdhoc[url]
+	if !ok {
+		d = newDestination("webhook "+url, newWebhookSender(Webhook{URL: url}), nil, 0)
+		c.urlToAdhoc[url] = d
+	}
+	c.mu.Unlock()
+	d.enqueue(e)
+}
+
+func (d *destination) accepts(t application.EventType) bool {
+	return len(d.filter) == 0 || d.filter[t]
+}
+
+func (d *destination) enqueue(e application.Event) {
+	select {
+	case d.queue <- e:
+	default:
+		slog.Warn("notify drop as queue full", "to", d.name, "type", e.Type, "appID", e.AppID)
+	}
+}
@@ -0,0 +1,114 @@
+package notifier
+
+import (
+	"amah/client/application"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const DefaultWebhookRetries = 3
+const DefaultWebhookBackoff = time.Second
+
+// SignatureHeader carries HMAC-SHA256 of the body in hex with "sha256=" prefix, if Webhook.Secret is set.
+const SignatureHeader = "X-Amah-Signature"
+
+// EventHeader carries the application.EventType, for receivers to route without parse the body.
+const EventHeader = "X-Amah-Event"
+
+// Webhook POSTs each event as JSON to URL.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Secret  string                  // used to sign the body in SignatureHeader, empty means no signature
+	Events  []application.EventType // which types to send, empty means all
+	Retries int                     // zero means DefaultWebhookRetries, negative means never retry
+	Backoff time.Duration           // the first interval to retry, doubles on each one, zero means DefaultWebhookBackoff
+}
+
+type webhookSender struct {
+	Webhook
+	client *http.Client
+}
+
+func newWebhookSender(webhook Webhook) *webhookSender {
+	if webhook.Retries == 0 {
+		webhook.Retries = DefaultWebhookRetries
+	}
+	if webhook.Backoff <= 0 {
+		webhook.Backoff = DefaultWebhookBackoff
+	}
+	return &webhookSender{
+		Webhook: webhook,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// errPermanent marks a failure that would not be better on retry.
+var errPermanent = errors.New("permanent")
+
+func (s *webhookSender) send(events []application.Event) error {
+	for _, e := range events {
+		if err := s.sendOne(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *webhookSender) sendOne(e application.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := s.Backoff
+	for i := 0; ; i++ {
+		err = s.post(e.Type, data)
+		if err == nil || errors.Is(err, errPermanent) || i >= s.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *webhookSender) post(t application.EventType, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(t))
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, data))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook got %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: webhook got %s", errPermanent, resp.Status)
+	}
+}
+
+// Sign returns the value of SignatureHeader, receivers could use it to verify.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"amah/client/application"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_NotifyWebhook(t *testing.T) {
+	const secret = "s3cr3t"
+	failures := 2
+	received := make(chan application.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get(SignatureHeader), Sign(secret, body); got != want {
+			t.Errorf("signature got = %v, want %v", got, want)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer x" {
+			t.Errorf("header Authorization got = %v", got)
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e application.Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		if got := r.Header.Get(EventHeader); got != string(e.Type) {
+			t.Errorf("header %s got = %v, want %v", EventHeader, got, e.Type)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	c, err := NewClient(Config{Webhooks: []Webhook{{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer x"},
+		Secret:  secret,
+		Events:  []application.EventType{application.EventCrash},
+		Retries: 2,
+		Backoff: time.Millisecond,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Notify(application.Event{AppID: 1, Type: application.EventStart})
+	c.Notify(application.Event{AppID: 1, Type: application.EventCrash, Message: "exit status 1"})
+
+	select {
+	case e := <-received:
+		if e.Type != application.EventCrash || e.Message != "exit status 1" {
+			t.Errorf("received %+v, want the crash", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("received nothing")
+	}
+	select {
+	case e := <-received:
+		t.Errorf("received %+v, which shall be filtered", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookSender_permanent(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := newWebhookSender(Webhook{URL: server.URL, Backoff: time.Millisecond})
+	if err := s.send([]application.Event{{Type: application.EventStart}}); err == nil {
+		t.Error("send() err = nil, want one")
+	}
+	if count != 1 {
+		t.Errorf("posted %d times, want no retry", count)
+	}
+}
+
+func TestClient_NotifyWebhook_rule(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e application.Event
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		received <- r.URL.Path + " " + e.Message + " " + r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(Config{Webhooks: []Webhook{
+		{URL: server.URL + "/all", Secret: "s"},
+		{URL: server.URL + "/crash", Secret: "s", Events: []application.EventType{application.EventCrash}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := application.Event{AppID: 1, Type: application.EventAlert, Message: "oom"}
+	c.NotifyWebhook(server.URL+"/all", alert)   // Notify sends it
+	c.NotifyWebhook(server.URL+"/crash", alert) // signed as in Config
+	c.NotifyWebhook(server.URL+"/adhoc", alert) // not signed
+
+	want := map[string]bool{
+		"/crash oom " + Sign("s", mustMarshal(t, alert)): true,
+		"/adhoc oom ": true,
+	}
+	for range want {
+		select {
+		case got := <-received:
+			if !want[got] {
+				t.Errorf("received %q, want one of %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("received nothing")
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("received %q, which shall be sent by Notify only", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"amah/client/auth"
+	"amah/service"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Those Route.Auth, for upstreams without authentication of their own.
+const (
+	AuthBasic   = "basic"   // HTTP Basic on amah accounts, but those with TOTP which Basic can not carry
+	AuthSession = "session" // the session cookie of amah, redirect to Route.LoginURL without it
+)
+
+// DefaultUsernameHeader is where the authenticated username is forwarded to the upstream.
+const DefaultUsernameHeader = "X-Amah-User"
+
+// basicCacheTTL is how long a verified Basic credential is cached, as browsers send it on each request
+// and bcrypt on each one would be too slow.
+const basicCacheTTL = time.Minute
+
+// basicCacheSweepSize is how many credentials are cached before the expired ones are swept.
+const basicCacheSweepSize = 1024
+
+// authenticator checks requests on routes with Auth.
+type authenticator struct {
+	client     *auth.Client
+	lockout    *auth.Lockout // nullable, nil means never lock
+	mu         sync.Mutex
+	basicCache map[string]time.Time // digest of username and password to when it expires
+}
+
+func validateAuth(r Route) error {
+	switch r.Auth {
+	case "", AuthBasic, AuthSession:
+	default:
+		return fmt.Errorf("route %s: unknown auth %s, shall be basic or session", r.Prefix, r.Auth)
+	}
+	if r.Role != "" {
+		if _, err := auth.ParseRole(string(r.Role)); err != nil {
+			return fmt.Errorf("route %s: %v", r.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// authenticate returns the username, or writes the response and false.
+func (a *authenticator) authenticate(writer http.ResponseWriter, request *http.Request, clientIP string, r *route) (string, bool) {
+	var account auth.Account
+	var ok bool
+	switch r.Auth {
+	case AuthBasic:
+		account, ok = a.basic(request, clientIP)
+		if !ok {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="amah", charset="UTF-8"`)
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return "", false
+		}
+	case AuthSession:
+		account, ok = a.session(request)
+		if !ok {
+			if r.LoginURL == "" {
+				http.Error(writer, "unauthorized", http.StatusUnauthorized)
+				return "", false
+			}
+			http.Redirect(writer, request, loginURL(r.LoginURL, request), http.StatusFound)
+			return "", false
+		}
+	}
+	role := r.Role
+	if role == "" {
+		role = auth.RoleViewer
+	}
+	if !account.Role.Covers(role) {
+		http.Error(writer, fmt.Sprintf("%s as %s can not access route %s", account.Username, account.Role, r.Prefix), http.StatusForbidden)
+		return "", false
+	}
+	return account.Username, true
+}
+
+// loginURL appends the original request as query parameter next, to go back after login.
+func loginURL(login string, request *http.Request) string {
+	separator := "?"
+	if strings.Contains(login, "?") {
+		separator = "&"
+	}
+	return login + separator + "next=" + url.QueryEscape(request.URL.RequestURI())
+}
+
+func (a *authenticator) session(request *http.Request) (auth.Account, bool) {
+	cookie, err := request.Cookie(service.SessionCookie)
+	if err != nil {
+		return auth.Account{}, false
+	}
+	t, ok := a.client.FindValidToken(cookie.Value)
+	if !ok {
+		return auth.Account{}, false
+	}
+	account, ok := a.client.FindAccount(t.Username)
+	if !ok || account.Disabled() {
+		return auth.Account{}, false
+	}
+	return account, true
+}
+
+func (a *authenticator) basic(request *http.Request, clientIP string) (auth.Account, bool) {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return auth.Account{}, false
+	}
+	account, ok := a.client.FindAccount(username)
+	if !ok || account.Disabled() || account.TOTPSecret != "" {
+		return auth.Account{}, false
+	}
+	now := time.Now()
+	sum := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + account.EncryptedPassword))
+	digest := hex.EncodeToString(sum[:])
+	a.mu.Lock()
+	expireAt, cached := a.basicCache[digest]
+	a.mu.Unlock()
+	if cached && now.Before(expireAt) {
+		return account, true
+	}
+
+	// Failures count together with those on the control plane, which records the locks.
+	if a.lockout != nil {
+		if _, _, ok := a.lockout.AcquireLogin(username, clientIP, now); !ok {
+			return auth.Account{}, false
+		}
+	}
+	ok, err := a.client.Auth(username, password)
+	if err != nil {
+		if a.lockout != nil {
+			a.lockout.ReleaseLogin(username, clientIP)
+		}
+		slog.Error("gateway basic auth", "err", err, "user", username)
+		return auth.Account{}, false
+	}
+	if !ok {
+		if a.lockout != nil {
+			a.lockout.FailLogin(username, clientIP, now)
+		}
+		return auth.Account{}, false
+	}
+	if a.lockout != nil {
+		a.lockout.SucceedLogin(username, clientIP)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.basicCache) >= basicCacheSweepSize {
+		for k, expireAt := range a.basicCache {
+			if !now.Before(expireAt) {
+				delete(a.basicCache, k)
+			}
+		}
+	}
+	a.basicCache[digest] = now.Add(basicCacheTTL)
+	return account, true
+}
+
+// stripCredentials keeps those of amah from the upstream, which may log or leak them.
+func stripCredentials(request *http.Request, r *route) {
+	if r.Auth == AuthBasic {
+		request.Header.Del("Authorization")
+	}
+	service.StripSessionCookies(request.Header)
+}
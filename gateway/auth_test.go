@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"amah/client/auth"
+	"amah/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGateway_ServeHTTP_auth(t *testing.T) {
+	hash, err := auth.EncryptPassword("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := auth.NewClient([]auth.Account{
+		{Username: "alice", EncryptedPassword: hash, Role: auth.RoleOperator},
+		{Username: "bob", EncryptedPassword: hash, Role: auth.RoleViewer},
+		{Username: "carol", EncryptedPassword: hash, Role: auth.RoleAdmin, TOTPSecret: "JBSWY3DPEHPK3PXP"},
+	}, auth.NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.CreateToken("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *http.Request
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		got = request
+	})
+	g, err := New(Config{
+		Routes: []Route{
+			{Prefix: "/admin", Auth: AuthBasic, Role: auth.RoleOperator},
+			{Prefix: "/dashboard", Auth: AuthSession, LoginURL: "/login", UsernameHeader: "X-Remote-User"},
+			{Prefix: "/api", Auth: AuthSession},
+			{Prefix: "/"},
+		},
+	}, next, nil, client, auth.NewLockout(2, time.Minute, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		path         string
+		basic        []string
+		cookies      map[string]string
+		header       map[string]string
+		want         int
+		wantHeader   map[string]string
+		wantUpstream map[string]string
+	}{
+		{"basic", "/admin", []string{"alice", "123456"}, nil, map[string]string{DefaultUsernameHeader: "mallory"}, http.StatusOK,
+			nil, map[string]string{DefaultUsernameHeader: "alice", "Authorization": ""}},
+		{"basic cached", "/admin/users", []string{"alice", "123456"}, nil, nil, http.StatusOK,
+			nil, map[string]string{DefaultUsernameHeader: "alice"}},
+		{"basic without", "/admin", nil, nil, nil, http.StatusUnauthorized,
+			map[string]string{"WWW-Authenticate": `Basic realm="amah", charset="UTF-8"`}, nil},
+		{"basic bad password", "/admin", []string{"alice", "654321"}, nil, nil, http.StatusUnauthorized, nil, nil},
+		{"basic role", "/admin", []string{"bob", "123456"}, nil, nil, http.StatusForbidden, nil, nil},
+		{"basic with TOTP", "/admin", []string{"carol", "123456"}, nil, nil, http.StatusUnauthorized, nil, nil},
+		{"session", "/dashboard", nil, map[string]string{service.SessionCookie: token.ID, "theme": "dark"}, nil, http.StatusOK,
+			nil, map[string]string{"X-Remote-User": "bob", "Cookie": "theme=dark"}},
+		{"session without", "/dashboard/a?b=1", nil, nil, nil, http.StatusFound,
+			map[string]string{"Location": "/login?next=%2Fdashboard%2Fa%3Fb%3D1"}, nil},
+		{"session bad", "/dashboard", nil, map[string]string{service.SessionCookie: "nonsense"}, nil, http.StatusFound, nil, nil},
+		{"session without login", "/api", nil, nil, nil, http.StatusUnauthorized, nil, nil},
+		{"no auth", "/", nil, nil, map[string]string{DefaultUsernameHeader: "mallory"}, http.StatusOK,
+			nil, map[string]string{DefaultUsernameHeader: ""}},
+		{"no auth other header", "/", nil, nil, map[string]string{"X-Remote-User": "mallory"}, http.StatusOK,
+			nil, map[string]string{"X-Remote-User": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.basic != nil {
+				request.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+			for k, v := range tt.cookies {
+				request.AddCookie(&http.Cookie{Name: k, Value: v})
+			}
+			for k, v := range tt.header {
+				request.Header.Set(k, v)
+			}
+			recorder := httptest.NewRecorder()
+			g.ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("ServeHTTP() code = %v, want %v", recorder.Code, tt.want)
+			}
+			for k, v := range tt.wantHeader {
+				if recorder.Header().Get(k) != v {
+					t.Errorf("ServeHTTP() header %s = %v, want %v", k, recorder.Header().Get(k), v)
+				}
+			}
+			if (got != nil) != (tt.want == http.StatusOK) {
+				t.Fatalf("ServeHTTP() upstream called = %v", got != nil)
+			}
+			for k, v := range tt.wantUpstream {
+				if got.Header.Get(k) != v {
+					t.Errorf("upstream header %s = %v, want %v", k, got.Header.Get(k), v)
+				}
+			}
+		})
+	}
+}
+
+func TestGateway_ServeHTTP_unmatchedUsernameHeader(t *testing.T) {
+	var got *http.Request
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		got = request
+	})
+	g, err := New(Config{
+		Routes: []Route{{Prefix: "/dashboard", UsernameHeader: "X-Remote-User"}},
+	}, next, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/other", nil)
+	request.Header.Set(DefaultUsernameHeader, "mallory")
+	request.Header.Set("X-Remote-User", "mallory")
+	g.ServeHTTP(httptest.NewRecorder(), request)
+	if got == nil {
+		t.Fatal("ServeHTTP() upstream not called")
+	}
+	for _, k := range []string{DefaultUsernameHeader, "X-Remote-User"} {
+		if v := got.Header.Get(k); v != "" {
+			t.Errorf("upstream header %s = %v, want empty", k, v)
+		}
+	}
+}
+
+func TestGateway_ServeHTTP_authLockout(t *testing.T) {
+	hash, err := auth.EncryptPassword("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := auth.NewClient([]auth.Account{
+		{Username: "alice", EncryptedPassword: hash, Role: auth.RoleAdmin},
+	}, auth.NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(Config{Routes: []Route{{Prefix: "/", Auth: AuthBasic}}},
+		http.NotFoundHandler(), nil, client, auth.NewLockout(2, time.Minute, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(password string) int {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.SetBasicAuth("alice", password)
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized} {
+		if got := serve("654321"); got != want {
+			t.Errorf("ServeHTTP() failure %d = %v, want %v", i, got, want)
+		}
+	}
+	if got := serve("123456"); got != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP() locked out = %v, want %v", got, http.StatusUnauthorized)
+	}
+}
+
+func TestNew_auth(t *testing.T) {
+	client, err := auth.NewClient(nil, auth.NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		route  Route
+		client *auth.Client
+	}{
+		{"unknown auth", Route{Prefix: "/", Auth: "digest"}, client},
+		{"unknown role", Route{Prefix: "/", Auth: AuthBasic, Role: "root"}, client},
+		{"no client", Route{Prefix: "/", Auth: AuthSession}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(Config{Routes: []Route{tt.route}}, http.NotFoundHandler(), nil, tt.client, nil); err == nil {
+				t.Error("New() no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package gateway
+
+import (
+	"amah/client/audit"
+	"amah/client/auth"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config of the gateway in YAML. Without any route, every request passes.
+type Config struct {
+	// TrustedProxies are CIDRs or IPs of those in front of the gateway, likes a load balancer,
+	// whose X-Forwarded-For is taken to find the client.
+	TrustedProxies []string `yaml:"trustedProxies"`
+	Routes         []Route
+}
+
+// Route applies on requests under Prefix, the longest one matched wins.
+type Route struct {
+	Prefix string   // on path segments, /v1 matches /v1 and /v1/processes, not /v10
+	Allow  []string // CIDRs or IPs, empty means all
+	Deny   []string // go before Allow
+	// RateLimits all apply, each on its own key, likes one on ip and another on route.
+	RateLimits []RateLimit `yaml:"rateLimits"`
+	// MaxConcurrent is how many requests on this route could be in flight at once, 0 means no limit.
+	// It's per route, not per upstream, as the gateway does not know which upstream a route goes to,
+	// so routes to the same upstream each have a budget of their own.
+	// A WebSocket or a stream of events is in flight until it ends.
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// Auth is AuthBasic or AuthSession on amah accounts, for upstreams without authentication of their own,
+	// empty means none. The credentials of amah are not forwarded.
+	Auth string
+	Role auth.Role // the least role to pass Auth, empty means viewer
+	// LoginURL is where AuthSession redirects to without a session, with the original one in query parameter next.
+	// Empty means 401.
+	LoginURL string `yaml:"loginURL"`
+	// UsernameHeader is where the username passing Auth is forwarded, empty means DefaultUsernameHeader.
+	// It's always removed from the client.
+	UsernameHeader string `yaml:"usernameHeader"`
+}
+
+// LoadConfig reads the config file in YAML, an empty path means no route at all.
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return Config{}, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	var ret Config
+	if err := yaml.NewDecoder(file).Decode(&ret); err != nil {
+		return Config{}, err
+	}
+	return ret, nil
+}
+
+// Gateway checks requests on routes before next, which is the proxy to upstreams.
+type Gateway struct {
+	trustedProxies []netip.Prefix
+	routes         []*route // longer prefix first
+	ordered        []*route // as in config
+	next           http.Handler
+	auditLog       *audit.Log // nullable, nil means no audit
+	authenticator  *authenticator
+	// usernameHeaders are Route.UsernameHeader of all, removed from every request as any could be a claim.
+	usernameHeaders []string
+}
+
+type route struct {
+	Route
+	allow       []netip.Prefix
+	deny        []netip.Prefix
+	limiters    []*limiter
+	inFlight    chan struct{} // nil means no limit
+	forbidden   atomic.Int64
+	rateLimited atomic.Int64
+	overloaded  atomic.Int64
+}
+
+// RouteStats counts the rejected requests on a route since start.
+type RouteStats struct {
+	Prefix      string `json:"prefix"`
+	Forbidden   int64  `json:"forbidden"`   // by Allow or Deny
+	RateLimited int64  `json:"rateLimited"` // by RateLimits
+	Overloaded  int64  `json:"overloaded"`  // by MaxConcurrent
+	InFlight    int    `json:"inFlight"`
+}
+
+// New makes a Gateway. The authClient is for routes with Auth, whose failures count on lockout,
+// both are nullable if there is no such route.
+func New(config Config, next http.Handler, auditLog *audit.Log, authClient *auth.Client, lockout *auth.Lockout) (*Gateway, error) {
+	trustedProxies, err := parsePrefixes(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trustedProxies: %v", err)
+	}
+	var routes []*route
+	for _, r := range config.Routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return nil, fmt.Errorf("route prefix %q shall start with /", r.Prefix)
+		}
+		allow, err := parsePrefixes(r.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("route %s allow: %v", r.Prefix, err)
+		}
+		deny, err := parsePrefixes(r.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("route %s deny: %v", r.Prefix, err)
+		}
+		if err := validateAuth(r); err != nil {
+			return nil, err
+		}
+		if r.Auth != "" && authClient == nil {
+			return nil, fmt.Errorf("route %s: no account for auth", r.Prefix)
+		}
+		if r.UsernameHeader == "" {
+			r.UsernameHeader = DefaultUsernameHeader
+		}
+		var limiters []*limiter
+		for _, limit := range r.RateLimits {
+			one, err := newLimiter(limit)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %v", r.Prefix, err)
+			}
+			limiters = append(limiters, one)
+		}
+		var inFlight chan struct{}
+		if r.MaxConcurrent > 0 {
+			inFlight = make(chan struct{}, r.MaxConcurrent)
+		}
+		routes = append(routes, &route{
+			Route:    r,
+			allow:    allow,
+			deny:     deny,
+			limiters: limiters,
+			inFlight: inFlight,
+		})
+	}
+	usernameHeaders := []string{DefaultUsernameHeader}
+	for _, r := range routes {
+		if header := http.CanonicalHeaderKey(r.UsernameHeader); !slices.Contains(usernameHeaders, header) {
+			usernameHeaders = append(usernameHeaders, header)
+		}
+	}
+	ordered := slices.Clone(routes)
+	slices.SortStableFunc(routes, func(a, b *route) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+	return &Gateway{
+		trustedProxies: trustedProxies,
+		routes:         routes,
+		ordered:        ordered,
+		next:           next,
+		auditLog:       auditLog,
+		authenticator: &authenticator{
+			client:     authClient,
+			lockout:    lockout,
+			mu:         sync.Mutex{},
+			basicCache: make(map[string]time.Time),
+		},
+		usernameHeaders: usernameHeaders,
+	}, nil
+}
+
+// parsePrefixes takes an IP as the prefix of its full length.
+func parsePrefixes(ss []string) ([]netip.Prefix, error) {
+	var ret []netip.Prefix
+	for _, s := range ss {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, prefix.Masked())
+	}
+	return ret, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// match returns the route of path, or nil. The path is cleaned, or /v1/../admin would escape the route of /admin,
+// which the upstream may take as /admin.
+func (g *Gateway) match(p string) *route {
+	p = path.Clean("/" + p)
+	for _, r := range g.routes {
+		prefix := strings.TrimSuffix(r.Prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return r
+		}
+	}
+	return nil
+}
+
+// permits tells whether the client could pass, an address not known is only permitted without Allow and Deny.
+func (r *route) permits(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return len(r.allow) == 0 && len(r.deny) == 0
+	}
+	if containsAddr(r.deny, addr) {
+		return false
+	}
+	return len(r.allow) == 0 || containsAddr(r.allow, addr)
+}
+
+// clientAddr is the remote address, or the nearest one not trusted in X-Forwarded-For if that is a trusted proxy.
+func (g *Gateway) clientAddr(request *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ret, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ret = ret.Unmap()
+	var forwarded []string
+	for _, value := range request.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0 && containsAddr(g.trustedProxies, ret); i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ret = addr.Unmap()
+	}
+	return ret
+}
+
+const ctxClientIPKey = "clientIP"
+
+// ClientIP returns the client found by the gateway, or empty if not through it.
+func ClientIP(ctx context.Context) string {
+	ret, _ := ctx.Value(ctxClientIPKey).(string)
+	return ret
+}
+
+func (g *Gateway) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	addr := g.clientAddr(request)
+	var clientIP string
+	if addr.IsValid() {
+		clientIP = addr.String()
+	}
+	request = request.WithContext(context.WithValue(request.Context(), ctxClientIPKey, clientIP))
+	for _, header := range g.usernameHeaders {
+		request.Header.Del(header)
+	}
+	r := g.match(request.URL.Path)
+	if r == nil {
+		g.next.ServeHTTP(writer, request)
+		return
+	}
+	if !r.permits(addr) {
+		r.forbidden.Add(1)
+		g.reject(writer, request, clientIP, r)
+		return
+	}
+	now := time.Now()
+	for _, l := range r.limiters {
+		if ok, wait := l.take(l.key(request, clientIP), now); !ok {
+			r.rateLimited.Add(1)
+			writer.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(writer, fmt.Sprintf("rate limited on route %s", r.Prefix), http.StatusTooManyRequests)
+			return
+		}
+	}
+	if r.Auth != "" {
+		username, ok := g.authenticator.authenticate(writer, request, clientIP, r)
+		if !ok {
+			return
+		}
+		stripCredentials(request, r)
+		request.Header.Set(r.UsernameHeader, username)
+	}
+	if r.inFlight != nil {
+		select {
+		case r.inFlight <- struct{}{}:
+			defer func() {
+				<-r.inFlight
+			}()
+		default:
+			r.overloaded.Add(1)
+			writer.Header().Set("Retry-After", "1")
+			http.Error(writer, fmt.Sprintf("too many requests in flight on route %s", r.Prefix), http.StatusTooManyRequests)
+			return
+		}
+	}
+	g.next.ServeHTTP(writer, request)
+}
+
+// Stats returns those of each route, in the order of config.
+func (g *Gateway) Stats() []RouteStats {
+	ret := make([]RouteStats, 0, len(g.routes))
+	for _, r := range g.ordered {
+		ret = append(ret, RouteStats{
+			Prefix:      r.Prefix,
+			Forbidden:   r.forbidden.Load(),
+			RateLimited: r.rateLimited.Load(),
+			Overloaded:  r.overloaded.Load(),
+			InFlight:    len(r.inFlight),
+		})
+	}
+	return ret
+}
+
+func (g *Gateway) reject(writer http.ResponseWriter, request *http.Request, clientIP string, r *route) {
+	message := fmt.Sprintf("%s not permitted on route %s", clientIP, r.Prefix)
+	slog.Warn("gateway reject", "ip", clientIP, "route", r.Prefix, "path", request.URL.Path)
+	if g.auditLog != nil {
+		err := g.auditLog.Append(audit.Record{
+			Time:    time.Now(),
+			Source:  clientIP,
+			Action:  "GatewayReject",
+			Target:  request.Method + " " + request.URL.Path,
+			Outcome: http.StatusForbidden,
+			Error:   message,
+		})
+		if err != nil {
+			slog.Error("audit append", "err", err, "action", "GatewayReject")
+		}
+	}
+	http.Error(writer, message, http.StatusForbidden)
+}
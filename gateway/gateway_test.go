@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"amah/client/audit"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGateway_ServeHTTP(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotClientIP string
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gotClientIP = ClientIP(request.Context())
+	})
+	g, err := New(Config{
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Routes: []Route{
+			{Prefix: "/v1", Allow: []string{"203.0.113.0/24", "2001:db8::/32"}, Deny: []string{"203.0.113.13"}},
+			{Prefix: "/v1/public/"},
+			{Prefix: "/", Deny: []string{"198.51.100.0/24"}},
+		},
+	}, next, auditLog, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		forwarded    []string
+		want         int
+		wantClientIP string
+	}{
+		{"allowed", "/v1/processes", "203.0.113.1:1234", nil, http.StatusOK, "203.0.113.1"},
+		{"allowed v6", "/v1/processes", "[2001:db8::1]:1234", nil, http.StatusOK, "2001:db8::1"},
+		{"not allowed", "/v1/processes", "192.0.2.1:1234", nil, http.StatusForbidden, ""},
+		{"denied in allowed", "/v1", "203.0.113.13:1234", nil, http.StatusForbidden, ""},
+		{"longer prefix", "/v1/public/a", "192.0.2.1:1234", nil, http.StatusOK, "192.0.2.1"},
+		{"on segments", "/v10", "192.0.2.1:1234", nil, http.StatusOK, "192.0.2.1"},
+		{"dot dot", "/other/../v1/processes", "192.0.2.1:1234", nil, http.StatusForbidden, ""},
+		{"fallback denied", "/app", "198.51.100.7:1234", nil, http.StatusForbidden, ""},
+		{"trusted proxy", "/v1", "10.1.1.1:1234", []string{"203.0.113.1"}, http.StatusOK, "203.0.113.1"},
+		{"trusted proxies", "/v1", "10.1.1.1:1234", []string{"192.0.2.1, 203.0.113.1", "10.2.2.2"}, http.StatusOK, "203.0.113.1"},
+		{"spoofed through trusted proxy", "/v1", "10.1.1.1:1234", []string{"203.0.113.1, 192.0.2.1"}, http.StatusForbidden, ""},
+		{"spoofed", "/v1", "192.0.2.1:1234", []string{"203.0.113.1"}, http.StatusForbidden, ""},
+		{"bad forwarded", "/", "10.1.1.1:1234", []string{"nonsense"}, http.StatusOK, "10.1.1.1"},
+	}
+	rejected := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotClientIP = ""
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			request.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				request.Header.Add("X-Forwarded-For", value)
+			}
+			recorder := httptest.NewRecorder()
+			g.ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("ServeHTTP() code = %v, want %v", recorder.Code, tt.want)
+			}
+			if gotClientIP != tt.wantClientIP {
+				t.Errorf("ClientIP() = %v, want %v", gotClientIP, tt.wantClientIP)
+			}
+		})
+		if tt.want == http.StatusForbidden {
+			rejected++
+		}
+	}
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), `"Action":"GatewayReject"`); got != rejected {
+		t.Errorf("audit records = %v, want %v", got, rejected)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"bad trusted proxy", Config{TrustedProxies: []string{"10.0.0.0/33"}}},
+		{"bad allow", Config{Routes: []Route{{Prefix: "/v1", Allow: []string{"office"}}}}},
+		{"bad deny", Config{Routes: []Route{{Prefix: "/v1", Deny: []string{"1.2.3"}}}}},
+		{"bad prefix", Config{Routes: []Route{{Prefix: "v1"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config, http.NotFoundHandler(), nil, nil, nil); err == nil {
+				t.Error("New() no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket, which holds Burst requests at most and refills Rate per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	// Key is what a bucket is for, one of ip, key and route, default ip. An API key not valid is rejected
+	// by the control plane only after the limit, so one with key had better go with another on ip.
+	Key string
+}
+
+const (
+	LimitKeyIP    = "ip"    // the client found by Gateway
+	LimitKeyKey   = "key"   // the API key in Token or Authorization header, or the ip without it
+	LimitKeyRoute = "route" // one bucket for all on the route
+)
+
+// bucketMaxSize is how many buckets a limiter keeps at most, the least recently taken one is evicted for a new one,
+// so that a client cycling through addresses or keys could not exhaust memory.
+const bucketMaxSize = 1 << 16
+
+type limiter struct {
+	RateLimit
+	mu          sync.Mutex
+	keyToBucket map[string]*list.Element // of *bucket in lru
+	lru         *list.List               // least recently taken first
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(limit RateLimit) (*limiter, error) {
+	if limit.Key == "" {
+		limit.Key = LimitKeyIP
+	}
+	if limit.Key != LimitKeyIP && limit.Key != LimitKeyKey && limit.Key != LimitKeyRoute {
+		return nil, fmt.Errorf("unknown rate limit key %s, shall be ip, key or route", limit.Key)
+	}
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return nil, fmt.Errorf("rate limit shall have positive rate and burst")
+	}
+	return &limiter{
+		RateLimit:   limit,
+		mu:          sync.Mutex{},
+		keyToBucket: make(map[string]*list.Element),
+		lru:         list.New(),
+	}, nil
+}
+
+// key returns the bucket key of the request.
+func (l *limiter) key(request *http.Request, clientIP string) string {
+	switch l.Key {
+	case LimitKeyRoute:
+		return ""
+	case LimitKeyKey:
+		if key := apiKeyOf(request); key != "" {
+			// Not the key itself, which is a credential.
+			sum := sha256.Sum256([]byte(key))
+			return "key:" + hex.EncodeToString(sum[:])
+		}
+	}
+	return "ip:" + clientIP
+}
+
+// apiKeyOf finds the API key likes the control plane does, see service.headerToken.
+func apiKeyOf(request *http.Request) string {
+	token := request.Header.Get("Token")
+	if token == "" {
+		token, _ = strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	}
+	if !strings.HasPrefix(token, "amah_") {
+		return ""
+	}
+	return token
+}
+
+// take returns true if a token is taken from the bucket on key, or how long until one is there.
+func (l *limiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	var b *bucket
+	if e, ok := l.keyToBucket[key]; ok {
+		b = e.Value.(*bucket)
+		l.lru.MoveToBack(e)
+	} else {
+		if l.lru.Len() >= bucketMaxSize {
+			l.remove(l.lru.Front())
+		}
+		b = &bucket{key: key, tokens: float64(l.Burst), last: now}
+		l.keyToBucket[key] = l.lru.PushBack(b)
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep removes buckets not taken for the time to refill from empty, which are full and as new.
+// Those are the least recently taken, so it only visits what it removes and one more.
+func (l *limiter) sweep(now time.Time) {
+	full := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	for e := l.lru.Front(); e != nil && now.Sub(e.Value.(*bucket).last) >= full; e = l.lru.Front() {
+		l.remove(e)
+	}
+}
+
+func (l *limiter) remove(e *list.Element) {
+	delete(l.keyToBucket, e.Value.(*bucket).key)
+	l.lru.Remove(e)
+}
+
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+}
+
+// retryAfter is in seconds rounded up, as the header takes.
+func retryAfter(d time.Duration) string {
+	return fmt.Sprint(int64(math.Ceil(d.Seconds())))
+}
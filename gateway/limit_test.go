@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_limiter_take(t *testing.T) {
+	l, err := newLimiter(RateLimit{Rate: 2, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		key      string
+		after    time.Duration
+		want     bool
+		wantWait time.Duration
+	}{
+		{"burst 1", "a", 0, true, 0},
+		{"burst 2", "a", 0, true, 0},
+		{"burst 3", "a", 0, true, 0},
+		{"empty", "a", 0, false, 500 * time.Millisecond},
+		{"other key", "b", 0, true, 0},
+		{"half refilled", "a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"refilled", "a", 250 * time.Millisecond, true, 0},
+		{"refilled to burst only", "a", time.Hour, true, 0},
+		{"burst 2 again", "a", 0, true, 0},
+		{"burst 3 again", "a", 0, true, 0},
+		{"empty again", "a", 0, false, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+			got, wait := l.take(tt.key, now)
+			if got != tt.want || wait != tt.wantWait {
+				t.Errorf("take() = %v, %v, want %v, %v", got, wait, tt.want, tt.wantWait)
+			}
+		})
+	}
+}
+
+func Test_limiter_sweep(t *testing.T) {
+	l, err := newLimiter(RateLimit{Rate: 1, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.take("a", now)
+	l.take("b", now.Add(time.Second))
+	l.take("c", now.Add(2*time.Second))
+	if _, ok := l.keyToBucket["a"]; ok || len(l.keyToBucket) != 2 {
+		t.Errorf("buckets after 2s = %v, want a refilled swept", len(l.keyToBucket))
+	}
+
+	for i := len(l.keyToBucket); i < bucketMaxSize; i++ {
+		l.take(fmt.Sprint(i), now.Add(2*time.Second))
+	}
+	l.take("new", now.Add(2*time.Second))
+	if _, ok := l.keyToBucket["b"]; ok || len(l.keyToBucket) != bucketMaxSize || l.lru.Len() != bucketMaxSize {
+		t.Errorf("buckets over max = %v, want %v without the least recently taken", len(l.keyToBucket), bucketMaxSize)
+	}
+}
+
+func Test_limiter_key(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		header map[string]string
+		want   string
+	}{
+		{"ip", LimitKeyIP, map[string]string{"Token": "amah_k"}, "ip:192.0.2.1"},
+		{"route", LimitKeyRoute, nil, ""},
+		{"key in Token", LimitKeyKey, map[string]string{"Token": "amah_k"}, "key:c7d5b23e7756b977259d606d5dd98012245ea55dd0426ab4e5294b1dde390449"},
+		{"key in Authorization", LimitKeyKey, map[string]string{"Authorization": "Bearer amah_k"}, "key:c7d5b23e7756b977259d606d5dd98012245ea55dd0426ab4e5294b1dde390449"},
+		{"token not key", LimitKeyKey, map[string]string{"Token": "uuid"}, "ip:192.0.2.1"},
+		{"no key", LimitKeyKey, nil, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newLimiter(RateLimit{Rate: 1, Burst: 1, Key: tt.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/v1/processes", nil)
+			for k, v := range tt.header {
+				request.Header.Set(k, v)
+			}
+			if got := l.key(request, "192.0.2.1"); got != tt.want {
+				t.Errorf("key() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGateway_ServeHTTP_limits(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	next := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+	})
+	g, err := New(Config{
+		Routes: []Route{
+			{Prefix: "/v1", RateLimits: []RateLimit{{Rate: 0.1, Burst: 2}}},
+			{Prefix: "/slow", MaxConcurrent: 1},
+		},
+	}, next, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path string, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := serve("/v1", "192.0.2.1:1234").Code; got != http.StatusOK {
+			t.Errorf("ServeHTTP() in burst code = %v", got)
+		}
+	}
+	got := serve("/v1", "192.0.2.1:1234")
+	if got.Code != http.StatusTooManyRequests || got.Header().Get("Retry-After") != "10" {
+		t.Errorf("ServeHTTP() over burst = %v with Retry-After %v", got.Code, got.Header().Get("Retry-After"))
+	}
+	if got := serve("/v1", "192.0.2.2:1234").Code; got != http.StatusOK {
+		t.Errorf("ServeHTTP() on another ip code = %v", got)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve("/slow", "192.0.2.1:1234")
+	}()
+	<-entered
+	if got := serve("/slow", "192.0.2.2:1234"); got.Code != http.StatusTooManyRequests || got.Header().Get("Retry-After") == "" {
+		t.Errorf("ServeHTTP() over MaxConcurrent = %v", got.Code)
+	}
+	close(release)
+	wg.Wait()
+	go func() {
+		<-entered
+	}()
+	if got := serve("/slow", "192.0.2.2:1234").Code; got != http.StatusOK {
+		t.Errorf("ServeHTTP() after release code = %v", got)
+	}
+
+	want := []RouteStats{
+		{Prefix: "/v1", RateLimited: 1},
+		{Prefix: "/slow", Overloaded: 1},
+	}
+	stats := g.Stats()
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("Stats()[%d] = %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+}
+
+func TestNew_limits(t *testing.T) {
+	for _, limit := range []RateLimit{{Rate: 1, Burst: 1, Key: "user"}, {Rate: 0, Burst: 1}, {Rate: 1, Burst: 0}} {
+		if _, err := New(Config{Routes: []Route{{Prefix: "/", RateLimits: []RateLimit{limit}}}}, http.NotFoundHandler(), nil, nil, nil); err == nil {
+			t.Errorf("New() on %+v no error", limit)
+		}
+	}
+}
@@ -3,13 +3,13 @@ module amah
 go 1.21
 
 require (
+	github.com/creack/pty v1.1.21
 	github.com/dustin/go-humanize v1.0.1
 	github.com/google/uuid v1.5.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/prometheus/procfs v0.12.0
 	golang.org/x/crypto v0.17.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	golang.org/x/sys v0.15.0 // indirect
-)
+require golang.org/x/sys v0.15.0 // indirect
@@ -2,19 +2,31 @@ package main
 
 import (
 	"amah/client/application"
+	"amah/client/audit"
 	"amah/client/auth"
 	"amah/client/monitor"
+	"amah/client/notifier"
+	"amah/gateway"
 	"amah/service"
-	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var scanMode = flag.Bool("scanMode", false, "enable scan mode")
@@ -22,36 +34,184 @@ var exeSuffix = flag.String("exeSuffix", "java", "match suffix of target applica
 var normalMode = flag.Bool("normalMode", true, "enable normal mode that works as gateway and keeper")
 var appConfigPath = flag.String("appConfigPath", "apps.yaml", "the applications config path")
 var shadowPath = flag.String("shadowPath", "shadow", "where the shadow file exist")
+var tokenPath = flag.String("tokenPath", "tokens", "where the login tokens are kept to survive restarts, empty means in memory only")
+var auditPath = flag.String("auditPath", "audit.log", "where the audit log is appended, empty means no audit")
+var loginFailureThreshold = flag.Int("loginFailureThreshold", 5, "how many login failures in a row on a username or an IP to lock it out, 0 means never")
+var loginLockout = flag.Duration("loginLockout", 30*time.Second, "the first lockout duration, which doubles on each failure more")
+var loginMaxLockout = flag.Duration("loginMaxLockout", time.Hour, "the max lockout duration, failures are also forgotten after it")
+var verifyAudit = flag.Bool("verifyAudit", false, "verify the hash chain of the audit log on auditPath and exit")
+var notifierConfigPath = flag.String("notifierConfigPath", "", "the notifier config path, empty means no notification")
 
 var listenAddress = flag.String("listenAddress", "0.0.0.0:8080", "where the server serve")
 var certFile = flag.String("certFile", "", "HTTPS cert filepath, not empty no HTTP")
 var keyFile = flag.String("keyFile", "", "HTTPS key filepath, not empty no HTTP")
+var clientAuth = flag.String("clientAuth", "none", "client certificate on HTTPS, one of none, accept and require")
+var clientCAFile = flag.String("clientCAFile", "", "the CA certs filepath to verify client certificates")
+var certRulePath = flag.String("certRulePath", "certrules", "where the username:identity lines map client certificates to accounts")
 
-var portBasic = flag.Int("portBasic", 8600, "where the control plane serve on localhost")
+var portBasic = flag.Int("portBasic", 8600, "where the control plane serve on localhost, if no socketPath")
+var socketPath = flag.String("socketPath", "", "the Unix socket where the control plane serve instead of portBasic, empty means not")
+var socketMode = flag.String("socketMode", "0660", "the permissions of socketPath in octal")
+var socketGroup = flag.String("socketGroup", "", "the group of socketPath, empty means as this process")
+var socketPeerAuth = flag.Bool("socketPeerAuth", false, "let a local user on socketPath without token act as the account of the same username")
 var addrOther = flag.String("addrOther", "https://localhost:8443", "where the fallback serve")
+var gatewayConfigPath = flag.String("gatewayConfigPath", "", "the gateway config path, with IP allow and deny lists of routes, empty means no route")
 
 var newUsername = flag.String("newUsername", "", "the new username to generate shadow line to append")
 var newPassword = flag.String("newPassword", "", "the new password to generate shadow line to append")
+var enrollTOTP = flag.String("enrollTOTP", "", "the username to enroll TOTP in shadowPath, whose secret, otpauth URI and recovery codes are printed")
+var newRole = flag.String("newRole", string(auth.RoleAdmin), "the role of new user, one of viewer, operator and admin")
+var newAppIDs = flag.String("newAppIDs", "", "the comma separated app IDs new user could operate on, empty means all")
 
-func NewProxy(basic *url.URL, other *url.URL) *httputil.ReverseProxy {
+var apiKeyPath = flag.String("apiKeyPath", "apikeys", "where the hashed API keys are kept, empty means no API key")
+var newKeyName = flag.String("newKeyName", "", "the name of new API key to add into apiKeyPath, which a running server takes in seconds")
+var newKeyScopes = flag.String("newKeyScopes", string(auth.ScopeRead), "the comma separated scopes of new API key, in read, start, stop and deploy")
+var newKeyAppIDs = flag.String("newKeyAppIDs", "", "the comma separated app IDs new API key could operate on, empty means all")
+var newKeyTTL = flag.Duration("newKeyTTL", 0, "how long new API key lasts, 0 means forever")
+var revokeKeyName = flag.String("revokeKeyName", "", "the name of API key to remove from apiKeyPath, which a running server takes in seconds")
+
+// NewProxy forwards the identities of a verified client certificate to basic, with gatewaySecret to prove it.
+func NewProxy(basic *url.URL, other *url.URL, gatewaySecret string) *httputil.ReverseProxy {
 	return &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
 			r.SetXForwarded()
+			// The client found by Gateway, which takes X-Forwarded-For from trusted proxies only.
+			if ip := gateway.ClientIP(r.In.Context()); ip != "" {
+				r.Out.Header.Set("X-Forwarded-For", ip)
+			}
+			// Never those from the client.
+			r.Out.Header.Del(service.CertHeader)
+			r.Out.Header.Del(service.GatewayHeader)
+			if r.In.TLS != nil && len(r.In.TLS.VerifiedChains) > 0 {
+				for _, identity := range auth.CertIdentities(r.In.TLS.VerifiedChains[0][0]) {
+					r.Out.Header.Add(service.CertHeader, identity)
+				}
+			}
 			// I have searched it in Eta0, the v1 prefix algorithm shall work. Expand it if this becomes more complex.
 			if strings.HasPrefix(r.In.URL.Path, "/v1") {
 				r.SetURL(basic)
 			} else {
 				r.SetURL(other)
+				service.StripSessionCookies(r.Out.Header)
+				return
 			}
+			r.Out.Header.Set(service.GatewayHeader, gatewaySecret)
 		},
 	}
 }
 
+// newTLSConfig verifies client certificates by the CA certs in caFile, as clientAuth says.
+func newTLSConfig(clientAuth string, caFile string) (*tls.Config, error) {
+	ret := &tls.Config{}
+	switch clientAuth {
+	case "none":
+		return ret, nil
+	case "accept":
+		ret.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown clientAuth %s, shall be none, accept or require", clientAuth)
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read clientCAFile: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no cert in clientCAFile %s", caFile)
+	}
+	ret.ClientCAs = pool
+	return ret, nil
+}
+
+// newGatewaySecret is random on each start, as the gateway and the control plane are in the same process.
+func newGatewaySecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// socketHost is the host in URL to the control plane on socketPath, which is never resolved.
+const socketHost = "amah.sock"
+
+// listenSocket listens on path with mode and group, replacing the stale socket left by the last run.
+func listenSocket(path string, mode string, group string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad socketMode %s: %v", mode, err)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() != os.ModeSocket {
+		return nil, fmt.Errorf("socketPath %s exists and is not a socket", path)
+	}
+	// The socket is created in a directory only for the owner, and moved to path after its mode and group are set,
+	// or others could connect in between.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".amah-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	tmp := filepath.Join(dir, filepath.Base(path))
+	ret, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// It's unlinked on close by the name created, which has gone.
+	ret.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, os.FileMode(perm)); err != nil {
+		_ = ret.Close()
+		return nil, err
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			_ = ret.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(tmp, -1, gid); err != nil {
+			_ = ret.Close()
+			return nil, err
+		}
+	}
+	// It replaces a stale socket at once.
+	if err := os.Rename(tmp, path); err != nil {
+		_ = ret.Close()
+		return nil, err
+	}
+	return ret, nil
+}
+
+// newSocketTransport dials the Unix socket on path for those to addr, and others as http.DefaultTransport.
+func newSocketTransport(addr string, path string) http.RoundTripper {
+	ret := http.DefaultTransport.(*http.Transport).Clone()
+	dial := ret.DialContext
+	ret.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if address == addr {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, address)
+	}
+	return ret
+}
+
 func main() {
 	flag.Parse()
 
 	if *newUsername != "" && *newPassword != "" {
-		line, err := auth.Register(*newUsername, *newPassword)
+		role, err := auth.ParseRole(*newRole)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appIDs, err := auth.ParseAppIDs(*newAppIDs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		line, err := auth.Register(*newUsername, *newPassword, role, appIDs)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -59,43 +219,184 @@ func main() {
 		return
 	}
 
+	if *enrollTOTP != "" {
+		client, err := auth.LoadClient(*shadowPath, auth.NewMemoryTokenStore())
+		if err != nil {
+			log.Fatal(err)
+		}
+		enrollment, err := client.EnrollTOTP(*enrollTOTP)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("secret: %s\nuri: %s\nrecovery codes:\n", enrollment.Secret, enrollment.URI)
+		for _, code := range enrollment.RecoveryCodes {
+			fmt.Println(code)
+		}
+		return
+	}
+
+	if *newKeyName != "" {
+		scopes, err := auth.ParseScopes(*newKeyScopes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appIDs, err := auth.ParseAppIDs(*newKeyAppIDs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var expireAt time.Time
+		if *newKeyTTL > 0 {
+			expireAt = time.Now().Add(*newKeyTTL)
+		}
+		key, k, err := auth.NewAPIKey(*newKeyName, scopes, appIDs, expireAt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store, err := auth.OpenKeyStore(*apiKeyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := store.Add(k); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	if *revokeKeyName != "" {
+		store, err := auth.OpenKeyStore(*apiKeyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		found, err := store.Revoke(*revokeKeyName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !found {
+			log.Fatalf("no key %s in %s", *revokeKeyName, *apiKeyPath)
+		}
+		return
+	}
+
+	if *verifyAudit {
+		count, err := audit.Verify(*auditPath)
+		if err != nil {
+			log.Fatalf("audit log %s bad after %d good records: %v", *auditPath, count, err)
+		}
+		fmt.Printf("audit log %s good with %d records\n", *auditPath, count)
+		return
+	}
+
 	if *normalMode {
-		file, err := os.ReadFile(*shadowPath)
+		var tokens auth.TokenStore = auth.NewMemoryTokenStore()
+		if *tokenPath != "" {
+			fileTokens, err := auth.OpenFileTokenStore(*tokenPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tokens = fileTokens
+		}
+		client, err := auth.LoadClient(*shadowPath, tokens)
 		if err != nil {
 			log.Fatal(err)
 		}
-		accounts, _ := auth.ParseShadow(bytes.NewReader(file))
-		client, err := auth.NewClient(accounts)
+		go client.RunSweeper(context.Background(), time.Minute)
+		go client.RunReloader(context.Background(), 5*time.Second)
+		keyStore, err := auth.OpenKeyStore(*apiKeyPath)
 		if err != nil {
 			log.Fatal(err)
 		}
+		go keyStore.RunReloader(context.Background(), 5*time.Second)
 		repository, err := application.NewRepository(*appConfigPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		c := service.New(client, monitor.NewClient(), repository)
-		// localhost so HTTP is acceptable
-		basic, err := url.Parse(fmt.Sprintf("http://localhost:%d", *portBasic))
+		notifierConfig, err := notifier.LoadConfig(*notifierConfigPath)
 		if err != nil {
 			log.Fatal(err)
 		}
-		go func() {
-			err = http.ListenAndServe(basic.Host, c)
+		notifierClient, err := notifier.NewClient(notifierConfig)
+		if err != nil {
 			log.Fatal(err)
-		}()
-
+		}
+		var auditLog *audit.Log
+		if *auditPath != "" {
+			if auditLog, err = audit.Open(*auditPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		// Shared by the gateway, so that login failures on both count together.
+		lockout := auth.NewLockout(*loginFailureThreshold, *loginLockout, *loginMaxLockout)
+		c := service.New(client, keyStore, monitor.NewClient(), repository, notifierClient, auditLog, lockout)
+		certRules, err := auth.LoadCertRules(*certRulePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.SetCertRules(certRules)
+		gatewaySecret, err := newGatewaySecret()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.TrustGateway(gatewaySecret)
 		other, err := url.Parse(*addrOther)
 		if err != nil {
 			log.Fatal(err)
 		}
-		p := NewProxy(basic, other)
+		var basic *url.URL
+		var serveBasic func() error
+		var transport http.RoundTripper
+		if *socketPath == "" {
+			// localhost so HTTP is acceptable
+			basic, err = url.Parse(fmt.Sprintf("http://localhost:%d", *portBasic))
+			if err != nil {
+				log.Fatal(err)
+			}
+			serveBasic = func() error {
+				return http.ListenAndServe(basic.Host, c)
+			}
+		} else {
+			listener, err := listenSocket(*socketPath, *socketMode, *socketGroup)
+			if err != nil {
+				log.Fatal(err)
+			}
+			c.AuthenticatePeers(*socketPeerAuth)
+			server := &http.Server{Handler: c, ConnContext: service.PeerConnContext}
+			serveBasic = func() error {
+				return server.Serve(listener)
+			}
+			basic = &url.URL{Scheme: "http", Host: socketHost}
+			transport = newSocketTransport(socketHost+":80", *socketPath)
+		}
+		p := NewProxy(basic, other, gatewaySecret)
+		p.Transport = transport
+		gatewayConfig, err := gateway.LoadConfig(*gatewayConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g, err := gateway.New(gatewayConfig, p, auditLog, client, lockout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.SetGatewayStats(func() any {
+			return g.Stats()
+		})
+		go func() {
+			err := serveBasic()
+			log.Fatal(err)
+		}()
+
 		log.Printf("listen on %s\n", *listenAddress)
 		if *certFile == "" && *keyFile == "" {
-			if err = http.ListenAndServe(*listenAddress, p); err != nil {
+			if err = http.ListenAndServe(*listenAddress, g); err != nil {
 				log.Fatal(err)
 			}
 		} else {
-			if err = http.ListenAndServeTLS(*listenAddress, *certFile, *keyFile, p); err != nil {
+			tlsConfig, err := newTLSConfig(*clientAuth, *clientCAFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			server := &http.Server{Addr: *listenAddress, Handler: g, TLSConfig: tlsConfig}
+			if err = server.ListenAndServeTLS(*certFile, *keyFile); err != nil {
 				log.Fatal(err)
 			}
 		}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"amah/client/auth"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	v1PostKey   = Exact(http.MethodPost, "/v1/keys")
+	v1GetKeys   = Exact(http.MethodGet, "/v1/keys")
+	v1DeleteKey = ResourceWithName(http.MethodDelete, "/v1/keys/", "")
+)
+
+type KeyInfo struct {
+	Name     string       `json:"name"`
+	Scopes   []auth.Scope `json:"scopes"`
+	AppIDs   []int        `json:"appIDs"`
+	ExpireAt time.Time    `json:"expireAt"` // zero means never
+}
+
+// CreatedKey has the key, which would never be shown again.
+type CreatedKey struct {
+	Key string `json:"key"`
+	auth.APIKey
+}
+
+func (s *Service) CreateKey(ctx context.Context, info *KeyInfo) (*CreatedKey, *CodedError) {
+	if _, e := s.authenticate(ctx, "CreateKey", info.Name); e != nil {
+		return nil, e
+	}
+	if len(info.Scopes) == 0 {
+		return nil, NewCodedErrorf(http.StatusBadRequest, "no scope")
+	}
+	for _, scope := range info.Scopes {
+		if !scope.Valid() {
+			return nil, NewCodedErrorf(http.StatusBadRequest, "unknown scope %s", scope)
+		}
+	}
+	key, k, err := auth.NewAPIKey(info.Name, info.Scopes, info.AppIDs, info.ExpireAt)
+	if err != nil {
+		return nil, NewCodedError(http.StatusBadRequest, err)
+	}
+	if err := s.keyStore.Add(k); err != nil {
+		if errors.Is(err, auth.ErrDuplicatedKeyName) {
+			return nil, NewCodedError(http.StatusConflict, err)
+		}
+		return nil, NewCodedError(http.StatusInternalServerError, err)
+	}
+	return &CreatedKey{Key: key, APIKey: k}, nil
+}
+
+func (s *Service) GetKeys(ctx context.Context) ([]auth.APIKey, *CodedError) {
+	if _, e := s.authenticate(ctx, "GetKeys", ""); e != nil {
+		return nil, e
+	}
+	return s.keyStore.List(), nil
+}
+
+func (s *Service) DeleteKey(ctx context.Context, name string) *CodedError {
+	if _, e := s.authenticate(ctx, "DeleteKey", name); e != nil {
+		return e
+	}
+	found, err := s.keyStore.Revoke(name)
+	if err != nil {
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+	if !found {
+		return NewCodedErrorf(http.StatusNotFound, "no key %s", name)
+	}
+	return nil
+}
@@ -16,7 +16,8 @@ type Node struct {
 
 type ApplicationComplex struct {
 	application.Application
-	Instances []*Node `json:",omitempty"`
+	Instances []*Node            `json:",omitempty"`
+	Status    application.Status `json:",omitempty"` // only available on those started by us
 }
 
 func CombineTheoryAndReality(applications []application.Application, processes []monitor.Process) []ApplicationComplex {
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"amah/client/audit"
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditEntry collects what to audit through a request, it's filled by authenticate and written after response.
+type auditEntry struct {
+	time    time.Time
+	source  string
+	method  string
+	action  string // empty means not authenticated, which is not audited.
+	target  string
+	user    string
+	tokenID string
+	err     string // message of the *CodedError from Handler, never the response body which may echo the request
+	cert    string // identity of the client certificate authenticated on, see CertHeader
+	peer    *Peer  // nil if not on a Unix socket
+}
+
+const ctxAuditKey = "audit"
+
+func attachAudit(ctx context.Context, entry *auditEntry) context.Context {
+	return context.WithValue(ctx, ctxAuditKey, entry)
+}
+
+// detachAudit returns the entry, or nil if it's not from a request, likes a restart on alert.
+func detachAudit(ctx context.Context) *auditEntry {
+	ret, _ := ctx.Value(ctxAuditKey).(*auditEntry)
+	return ret
+}
+
+// sourceIP finds the IP of the client. As the Service listens on localhost behind the gateway,
+// X-Forwarded-For from a loopback peer is trusted, which is set by the gateway.
+// On a Unix socket, so is that from the peer as this process, otherwise it's the Peer.
+func sourceIP(request *http.Request) string {
+	if peer, ok := detachPeer(request.Context()); ok {
+		if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" && peer.gateway() {
+			return lastForwarded(forwarded)
+		}
+		return peer.String()
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if forwarded := request.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return lastForwarded(forwarded)
+		}
+	}
+	return host
+}
+
+// lastForwarded returns the last one in X-Forwarded-For, which is the one appended by the nearest proxy.
+func lastForwarded(forwarded string) string {
+	return strings.TrimSpace(forwarded[strings.LastIndexByte(forwarded, ',')+1:])
+}
+
+// statusRecorder remembers the status code for audit, but not the body.
+// It keeps Flusher and Hijacker for SSE and WebSocket.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack unsupported")
+	}
+	r.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// serveAudited serves the request by next, and appends an audit.Record if it's authenticated.
+func (s *Service) serveAudited(writer http.ResponseWriter, request *http.Request, next http.Handler) {
+	entry := &auditEntry{
+		time:   time.Now(),
+		source: sourceIP(request),
+		method: request.Method,
+	}
+	if peer, ok := detachPeer(request.Context()); ok {
+		entry.peer = &peer
+	}
+	recorder := &statusRecorder{ResponseWriter: writer}
+	next.ServeHTTP(recorder, request.WithContext(attachAudit(request.Context(), entry)))
+
+	if entry.action == "" || s.auditLog == nil {
+		return
+	}
+	if recorder.code == 0 {
+		recorder.code = http.StatusOK
+	}
+	err := s.auditLog.Append(audit.Record{
+		Time:    entry.time,
+		User:    entry.user,
+		TokenID: audit.DigestToken(entry.tokenID),
+		Cert:    entry.cert,
+		Source:  entry.source,
+		Action:  entry.action,
+		Target:  entry.target,
+		Outcome: recorder.code,
+		Error:   entry.err,
+	})
+	if err != nil {
+		// The response has been sent, nothing else can I do here.
+		slog.Error("audit append", "err", err, "action", entry.action, "user", entry.user)
+	}
+}
+
+var v1GetAudit = Exact(http.MethodGet, "/v1/audit")
+
+// GetAudit returns records in the audit log, filtered by query parameters user, action, target and since in RFC 3339.
+// The latest limit ones are returned, 100 by default.
+func (s *Service) GetAudit(ctx context.Context) ([]audit.Record, *CodedError) {
+	if _, err := s.authenticate(ctx, "GetAudit", ""); err != nil {
+		return nil, err
+	}
+	if s.auditLog == nil {
+		return nil, NewCodedErrorf(http.StatusNotFound, "audit log not enabled")
+	}
+	query := DetachQuery(ctx)
+	user, action, target := query.Get("user"), query.Get("action"), query.Get("target")
+	var since time.Time
+	if str := query.Get("since"); str != "" {
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, NewCodedErrorf(http.StatusBadRequest, "bad since %s: %v", str, err)
+		}
+		since = t
+	}
+	limit := 100
+	if str := query.Get("limit"); str != "" {
+		num, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, NewCodedErrorf(http.StatusBadRequest, "bad limit %s: %v", str, err)
+		}
+		limit = num
+	}
+
+	ret, err := s.auditLog.Query(func(r audit.Record) bool {
+		return (user == "" || r.User == user) &&
+			(action == "" || r.Action == action) &&
+			(target == "" || r.Target == target) &&
+			!r.Time.Before(since)
+	}, limit)
+	if err != nil {
+		return nil, NewCodedError(http.StatusInternalServerError, err)
+	}
+	return ret, nil
+}
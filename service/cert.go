@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+// The gateway terminates TLS, so it tells the identities of a verified client certificate in CertHeader,
+// one value each as auth.CertIdentities. GatewayHeader proves the request is from the gateway,
+// otherwise any local process could claim a certificate.
+const (
+	CertHeader    = "X-Amah-Client-Cert"
+	GatewayHeader = "X-Amah-Gateway"
+)
+
+// TrustGateway accepts CertHeader on requests with the secret in GatewayHeader, empty means never.
+func (s *Service) TrustGateway(secret string) {
+	s.gatewaySecret = secret
+}
+
+// certIdentities returns those in CertHeader if the request is from the gateway.
+// A browser sends the certificate on any request like a cookie, so those with Origin, which browsers set
+// on requests but navigations, are not taken, and shall use cookie sessions with CSRF protection.
+func (s *Service) certIdentities(request *http.Request) []string {
+	if s.gatewaySecret == "" || request.Header.Get("Origin") != "" {
+		return nil
+	}
+	secret := request.Header.Get(GatewayHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.gatewaySecret)) != 1 {
+		return nil
+	}
+	return request.Header.Values(CertHeader)
+}
+
+const ctxCertsKey = "certs"
+
+// attachCerts carries the identities from certIdentities, apart from audit, so that any handler could authenticate.
+func attachCerts(ctx context.Context, identities []string) context.Context {
+	return context.WithValue(ctx, ctxCertsKey, identities)
+}
+
+func detachCerts(ctx context.Context) []string {
+	ret, _ := ctx.Value(ctxCertsKey).([]string)
+	return ret
+}
+
+func (s *Service) authenticateCert(entry *auditEntry, identities []string, action string, target string) (string, *CodedError) {
+	account, identity, ok := s.authClient.FindAccountByCert(identities)
+	if !ok || account.Disabled() {
+		return "", NewCodedErrorf(http.StatusForbidden, "no account on cert %v", identities)
+	}
+	if entry != nil {
+		entry.user, entry.cert = account.Username, identity
+	}
+	if e := authorize(account, action, target); e != nil {
+		return "", e
+	}
+	if entry != nil && entry.method != http.MethodGet {
+		slog.Info(action, "target", target, "user", account.Username, "cert", identities)
+	}
+	return account.Username, nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"amah/client/auth"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestService_certIdentities(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		header map[string]string
+		want   []string
+	}{
+		{"from gateway", "s", map[string]string{GatewayHeader: "s", CertHeader: "cn:ci-bot"}, []string{"cn:ci-bot"}},
+		{"bad secret", "s", map[string]string{GatewayHeader: "x", CertHeader: "cn:ci-bot"}, nil},
+		{"no secret", "s", map[string]string{CertHeader: "cn:ci-bot"}, nil},
+		{"not trusted", "", map[string]string{GatewayHeader: "", CertHeader: "cn:ci-bot"}, nil},
+		{"browser", "s", map[string]string{GatewayHeader: "s", CertHeader: "cn:ci-bot", "Origin": "https://evil.example.com"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{gatewaySecret: tt.secret}
+			request := httptest.NewRequest(http.MethodPost, "/v1/applications/1001/start", nil)
+			for k, v := range tt.header {
+				request.Header.Set(k, v)
+			}
+			if got := s.certIdentities(request); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("certIdentities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_authenticateCert(t *testing.T) {
+	c, err := auth.NewClient([]auth.Account{{Username: "ci", Role: auth.RoleAdmin}}, auth.NewMemoryTokenStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCertRules([]auth.CertRule{{Username: "ci", Identity: "cn:ci-bot"}})
+	s := &Service{authClient: c}
+	ctx := attachCerts(AttachToken(context.Background(), ""), []string{"cn:other", "cn:ci-bot"})
+	// Those not through serveAudited have no entry, but the certificate still works.
+	if got, e := s.authenticate(ctx, "GetProcesses", ""); e != nil || got != "ci" {
+		t.Errorf("authenticate() without audit = %v, %v, want ci", got, e)
+	}
+	entry := &auditEntry{method: http.MethodGet}
+	if _, e := s.authenticate(attachAudit(ctx, entry), "GetProcesses", ""); e != nil {
+		t.Fatal(e)
+	}
+	if entry.user != "ci" || entry.cert != "cn:ci-bot" {
+		t.Errorf("audit entry user = %v, cert = %v, want ci on cn:ci-bot", entry.user, entry.cert)
+	}
+}
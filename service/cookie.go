@@ -0,0 +1,133 @@
+package service
+
+import (
+	"amah/client/auth"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// A browser keeps the token in an HttpOnly cookie, which scripts in page can not read.
+// Mutating requests with it shall carry the CSRF cookie in the CSRF header, which only scripts
+// of the same site could read and set, as the double-submit pattern.
+const (
+	SessionCookie = "amah_session"
+	CSRFCookie    = "amah_csrf"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// sessionCookiePath is where browsers send cookies of amah, the control plane only, not apps behind it.
+const sessionCookiePath = "/v1"
+
+var errCSRF = errors.New("no or bad " + CSRFHeader + " on session cookie")
+
+// safeMethod are those do not change anything, which need no CSRF protection.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestToken finds the token in header, or else in the session cookie, for which CSRF is checked.
+// An error means the cookie is taken as absent, which only those need authentication would refuse.
+func requestToken(request *http.Request) (string, error) {
+	if token := headerToken(request); token != "" {
+		return token, nil
+	}
+	session, err := request.Cookie(SessionCookie)
+	if err != nil {
+		return "", nil
+	}
+	if safeMethod(request.Method) {
+		return session.Value, nil
+	}
+	csrf, err := request.Cookie(CSRFCookie)
+	if err != nil {
+		return "", errCSRF
+	}
+	got := request.Header.Get(CSRFHeader)
+	if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(csrf.Value)) != 1 {
+		return "", errCSRF
+	}
+	return session.Value, nil
+}
+
+// setSessionCookies issues cookies for browsers, if the response header is in ctx.
+func setSessionCookies(ctx context.Context, token auth.Token) error {
+	header := DetachHeader(ctx)
+	if header == nil {
+		return nil
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	maxAge := int(auth.TokenMaxLifetime.Seconds())
+	header.Add("Set-Cookie", (&http.Cookie{
+		Name:     SessionCookie,
+		Value:    token.ID,
+		Path:     sessionCookiePath,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}).String())
+	header.Add("Set-Cookie", (&http.Cookie{
+		Name:     CSRFCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     sessionCookiePath,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: false, // for scripts to read and submit
+		SameSite: http.SameSiteStrictMode,
+	}).String())
+	return nil
+}
+
+// clearSessionCookies removes cookies from browsers, if the response header is in ctx.
+func clearSessionCookies(ctx context.Context) {
+	header := DetachHeader(ctx)
+	if header == nil {
+		return
+	}
+	for _, name := range []string{SessionCookie, CSRFCookie} {
+		header.Add("Set-Cookie", (&http.Cookie{
+			Name:     name,
+			Path:     sessionCookiePath,
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: name == SessionCookie,
+			SameSite: http.SameSiteStrictMode,
+		}).String())
+	}
+}
+
+// StripSessionCookies removes cookies of amah from a request header, for those proxied to apps on the same site,
+// which could replay the session on the control plane otherwise. It cuts the pairs as text, as the apps' own cookies
+// could be those Go refuses to parse or quotes differently, and leaves the header untouched without those of amah.
+func StripSessionCookies(header http.Header) {
+	var kept []string
+	stripped := false
+	for _, line := range header.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			pair = strings.TrimSpace(pair)
+			name, _, _ := strings.Cut(pair, "=")
+			if name = strings.TrimSpace(name); name == SessionCookie || name == CSRFCookie {
+				stripped = true
+				continue
+			}
+			if pair != "" {
+				kept = append(kept, pair)
+			}
+		}
+	}
+	if !stripped {
+		return
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_requestToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		header  map[string]string
+		cookies map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"header", http.MethodPost, map[string]string{"Token": "t1"}, nil, "t1", false},
+		{"bearer", http.MethodPost, map[string]string{"Authorization": "Bearer t1"}, nil, "t1", false},
+		{"header over cookie", http.MethodPost, map[string]string{"Token": "t1"}, map[string]string{SessionCookie: "t2"}, "t1", false},
+		{"cookie on GET", http.MethodGet, nil, map[string]string{SessionCookie: "t2"}, "t2", false},
+		{"cookie with CSRF", http.MethodPost, map[string]string{CSRFHeader: "c"}, map[string]string{SessionCookie: "t2", CSRFCookie: "c"}, "t2", false},
+		{"cookie without CSRF header", http.MethodPost, nil, map[string]string{SessionCookie: "t2", CSRFCookie: "c"}, "", true},
+		{"cookie with bad CSRF", http.MethodDelete, map[string]string{CSRFHeader: "x"}, map[string]string{SessionCookie: "t2", CSRFCookie: "c"}, "", true},
+		{"cookie without CSRF cookie", http.MethodPut, map[string]string{CSRFHeader: "c"}, map[string]string{SessionCookie: "t2"}, "", true},
+		{"nothing", http.MethodPost, nil, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/v1/applications", nil)
+			for k, v := range tt.header {
+				request.Header.Set(k, v)
+			}
+			for k, v := range tt.cookies {
+				request.AddCookie(&http.Cookie{Name: k, Value: v})
+			}
+			got, err := requestToken(request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("requestToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestToken() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripSessionCookies(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"only amah", SessionCookie + "=t; " + CSRFCookie + "=c", ""},
+		{"others kept", "a=1; " + SessionCookie + "=t; b=2", "a=1; b=2"},
+		{"none", "", ""},
+		{"others untouched", `a=1; session={"u":"x"}; x=a,b`, `a=1; session={"u":"x"}; x=a,b`},
+		{"others as they were", `session={"u":"x"};` + SessionCookie + "=t;x=a,b", `session={"u":"x"}; x=a,b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.cookie != "" {
+				header.Set("Cookie", tt.cookie)
+			}
+			StripSessionCookies(header)
+			if got := header.Get("Cookie"); got != tt.want {
+				t.Errorf("StripSessionCookies() Cookie = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A stale cookie without CSRF header is taken as absent, which shall not stop Login.
+func TestWeb_ServeHTTP_cookieWithoutCSRF(t *testing.T) {
+	web := NewWeb(NewJSONHandler(
+		Exact(http.MethodPost, "/v1/session"),
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return DetachToken(ctx), nil
+		},
+	))
+	request := httptest.NewRequest(http.MethodPost, "/v1/session", strings.NewReader("{}"))
+	request.AddCookie(&http.Cookie{Name: SessionCookie, Value: "stale"})
+	request.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "c"})
+	recorder := httptest.NewRecorder()
+	web.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `""` {
+		t.Errorf("ServeHTTP() = %v %s, want 200 without token", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"amah/client/application"
+	"amah/ring"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Those events are from Service itself, the others are from application.Client.
+const (
+	EventLogin        application.EventType = "login"
+	EventLoginFailure application.EventType = "login failure"
+	EventKill         application.EventType = "kill"
+	EventConfigReload application.EventType = "config reload"
+)
+
+// SeqEvent is an event with its sequence number in Bus, which is the id in SSE to resume.
+type SeqEvent struct {
+	Seq uint64
+	application.Event
+}
+
+// Bus broadcasts events to subscribers, and keeps the recent ones for replay.
+type Bus struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     ring.Ring[SeqEvent]
+	subscribers map[chan SeqEvent]struct{}
+}
+
+func NewBus(historyLength int) *Bus {
+	return &Bus{
+		mu:          sync.Mutex{},
+		seq:         0,
+		history:     ring.New[SeqEvent](historyLength),
+		subscribers: make(map[chan SeqEvent]struct{}),
+	}
+}
+
+// Publish never blocks, a subscriber too slow to keep up would be unsubscribed, and it shall resume on Seq.
+func (b *Bus) Publish(e application.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	se := SeqEvent{Seq: b.seq, Event: e}
+	b.history.Add(se)
+	for ch := range b.subscribers {
+		select {
+		case ch <- se:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns those in history after the Seq, and the channel for the following ones.
+// The channel closes on cancel, or if the subscriber is too slow.
+func (b *Bus) Subscribe(afterSeq uint64) (history []SeqEvent, follow <-chan SeqEvent, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, se := range b.history.Get() {
+		if se.Seq > afterSeq {
+			history = append(history, se)
+		}
+	}
+	ch := make(chan SeqEvent, 64)
+	b.subscribers[ch] = struct{}{}
+	return history, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the event to everyone interested in, including those out of the process.
+func (s *Service) publish(e application.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.bus.Publish(e)
+	s.notifierClient.Notify(e)
+}
+
+// EventFilter selects events on AppIDs and Types, empty ones match all.
+type EventFilter struct {
+	AppIDs []int
+	Types  []application.EventType
+	// permitted limits app events to those the user could see, nil means all.
+	// Events of no app, whose AppID is zero, are not limited.
+	permitted []int
+}
+
+func (f EventFilter) Match(e application.Event) bool {
+	if f.permitted != nil && e.AppID != 0 && !slices.Contains(f.permitted, e.AppID) {
+		return false
+	}
+	if len(f.AppIDs) > 0 && !slices.Contains(f.AppIDs, e.AppID) {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	return true
+}
+
+func parseEventFilter(request *http.Request) (EventFilter, error) {
+	var ret EventFilter
+	query := request.URL.Query()
+	for _, ids := range query["appID"] {
+		for _, id := range strings.Split(ids, ",") {
+			num, err := strconv.Atoi(id)
+			if err != nil {
+				return EventFilter{}, fmt.Errorf("bad appID %s", id)
+			}
+			ret.AppIDs = append(ret.AppIDs, num)
+		}
+	}
+	for _, types := range query["type"] {
+		for _, t := range strings.Split(types, ",") {
+			ret.Types = append(ret.Types, application.EventType(t))
+		}
+	}
+	return ret, nil
+}
+
+var v1GetEvents = Exact(http.MethodGet, "/v1/events")
+
+// sseHeartbeat keeps proxies from closing an idle stream.
+const sseHeartbeat = 15 * time.Second
+
+// GetEvents returns events in history as JSON, or with follow=1 a Server-Sent Events stream
+// that replays the history and then follows. Query parameters appID and type filter them,
+// both could be comma separated or repeated. To resume a stream, the Last-Event-ID header works,
+// which EventSource sends on reconnect, and so does the query parameter after.
+func (s *Service) GetEvents(writer http.ResponseWriter, request *http.Request) {
+	ctx := AttachToken(request.Context(), tokenOf(request))
+	username, e := s.authenticate(ctx, "GetEvents", "")
+	if e != nil {
+		slog.Warn("resp " + e.Error())
+		http.Error(writer, e.Err.Error(), e.Code)
+		return
+	}
+	filter, err := parseEventFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.permitted = s.permittedApps(username)
+	after := request.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = request.URL.Query().Get("after")
+	}
+	afterSeq, _ := strconv.ParseUint(after, 10, 64)
+
+	history, follow, cancel := s.bus.Subscribe(afterSeq)
+	defer cancel()
+	matched := make([]SeqEvent, 0, len(history))
+	for _, se := range history {
+		if filter.Match(se.Event) {
+			matched = append(matched, se)
+		}
+	}
+
+	if ok, _ := strconv.ParseBool(request.URL.Query().Get("follow")); !ok {
+		data, err := json.Marshal(matched)
+		if err != nil {
+			slog.Error("unexpected failure on marshal", "err", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", JSONContentType)
+		_, _ = writer.Write(data)
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	for _, se := range matched {
+		if err := writeSSE(writer, se); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case se, ok := <-follow:
+			if !ok {
+				// Too slow, the client shall reconnect with Last-Event-ID.
+				return
+			}
+			if !filter.Match(se.Event) {
+				continue
+			}
+			if err := writeSSE(writer, se); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := writer.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(writer http.ResponseWriter, se SeqEvent) error {
+	data, err := json.Marshal(se)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: %s\ndata: %s\n\n", se.Seq, se.Type, data)
+	return err
+}
+
+// tokenOf finds the token in header or cookie, the latter is sent by WebSocket and EventSource in browsers
+// which can not set header. It's only for GET, which needs no CSRF check. There is no query parameter for it,
+// which would leave the token in access logs and browser history.
+func tokenOf(request *http.Request) string {
+	token, _ := requestToken(request)
+	return token
+}
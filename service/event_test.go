@@ -0,0 +1,57 @@
+package service
+
+import (
+	"amah/client/application"
+	"reflect"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus(2)
+	b.Publish(application.Event{Type: EventLogin})
+	b.Publish(application.Event{Type: EventKill})
+	b.Publish(application.Event{Type: EventConfigReload})
+
+	history, follow, cancel := b.Subscribe(0)
+	defer cancel()
+	want := []SeqEvent{{2, application.Event{Type: EventKill}}, {3, application.Event{Type: EventConfigReload}}}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("Subscribe(0) history = %v, want %v", history, want)
+	}
+	if history, _, c := b.Subscribe(2); len(history) != 1 || history[0].Seq != 3 {
+		t.Errorf("Subscribe(2) history = %v, want the 3rd only", history)
+	} else {
+		c()
+	}
+
+	b.Publish(application.Event{Type: EventLogin, User: "alice"})
+	if got := <-follow; got.Seq != 4 || got.User != "alice" {
+		t.Errorf("follow got = %v, want the 4th", got)
+	}
+	cancel()
+	if _, ok := <-follow; ok {
+		t.Error("follow not closed after cancel")
+	}
+}
+
+func TestEventFilter_Match(t *testing.T) {
+	e := application.Event{AppID: 1002, Type: application.EventCrash}
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   bool
+	}{
+		{"empty", EventFilter{}, true},
+		{"app", EventFilter{AppIDs: []int{1001, 1002}}, true},
+		{"other app", EventFilter{AppIDs: []int{1001}}, false},
+		{"type", EventFilter{Types: []application.EventType{application.EventCrash}}, true},
+		{"app but other type", EventFilter{AppIDs: []int{1002}, Types: []application.EventType{EventLogin}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(e); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
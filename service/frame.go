@@ -7,8 +7,8 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -31,56 +31,43 @@ func (e CodedError) Error() string {
 }
 
 type Handler interface {
-	Match(req *http.Request) bool
-	Parse(data []byte, path string) (any, error)
+	Route() Route
+	Parse(data []byte, params Params) (any, error)
 	Handle(ctx context.Context, req any) (rsp any, codedError *CodedError)
 	Format(output any) (data []byte, err error)
 	ResponseContentType() string // could use http.DetectContentType as default, which finds JSON as text/plain.
 }
 
-type MatchFunc func(req *http.Request) bool
-
-func Exact(method string, path string) MatchFunc {
-	return func(req *http.Request) bool {
-		return req.URL.Path == path && req.Method == method
-	}
+func Exact(method string, path string) Route {
+	return Route{Method: method, Pattern: path}
 }
 
-func ResourceWithID(method string, pathPrefixWithTailSlash string, pathSuffixWithHeadSlashNullable string) MatchFunc {
-	return func(req *http.Request) bool {
-		if req.Method != method {
-			return false
-		}
-		s, found := strings.CutPrefix(req.URL.Path, pathPrefixWithTailSlash)
-		if !found {
-			return false
-		}
-		if pathSuffixWithHeadSlashNullable != "" {
-			s, found = strings.CutSuffix(s, pathSuffixWithHeadSlashNullable)
-			if !found {
-				return false
-			}
-		}
-		if _, err := strconv.Atoi(s); err != nil {
-			return false
-		}
-		return true
-	}
+// ResourceWithID is the Route on an integer between the prefix and the suffix, as parameter id.
+func ResourceWithID(method string, pathPrefixWithTailSlash string, pathSuffixWithHeadSlashNullable string) Route {
+	return Route{Method: method, Pattern: pathPrefixWithTailSlash + "{id" + intParamSuffix + "}" + pathSuffixWithHeadSlashNullable}
 }
 
 type HandleFunc func(ctx context.Context, req any) (rsp any, codedError *CodedError)
 
+// TimeoutHandler is an optional interface of Handler, for those need a timeout other than DefaultTimeout.
+type TimeoutHandler interface {
+	Timeout() time.Duration
+}
+
+const DefaultTimeout = 1000 * time.Millisecond
+
 type ClosureHandler struct {
-	Matcher     MatchFunc
-	Parser      func(data []byte, path string) (any, error)
+	Matcher     Route
+	Parser      ParseFunc
 	Handler     HandleFunc
 	Formatter   func(output any) (data []byte, err error)
 	ContentType string
+	Threshold   time.Duration // zero means DefaultTimeout
 }
 
 const JSONContentType = "application/json; charset=utf-8"
 
-func NewJSONHandler(matcher MatchFunc, requestType reflect.Type, handler HandleFunc) *ClosureHandler {
+func NewJSONHandler(matcher Route, requestType reflect.Type, handler HandleFunc) *ClosureHandler {
 	return &ClosureHandler{
 		Matcher:     matcher,
 		Parser:      JSONParser(requestType),
@@ -94,12 +81,12 @@ func NewJSONHandler(matcher MatchFunc, requestType reflect.Type, handler HandleF
 type Empty struct {
 }
 
-func (ch *ClosureHandler) Match(req *http.Request) bool {
-	return ch.Matcher(req)
+func (ch *ClosureHandler) Route() Route {
+	return ch.Matcher
 }
 
-func (ch *ClosureHandler) Parse(data []byte, path string) (any, error) {
-	return ch.Parser(data, path)
+func (ch *ClosureHandler) Parse(data []byte, params Params) (any, error) {
+	return ch.Parser(data, params)
 }
 
 func (ch *ClosureHandler) Handle(
@@ -117,6 +104,13 @@ func (ch *ClosureHandler) ResponseContentType() string {
 	return ch.ContentType
 }
 
+func (ch *ClosureHandler) Timeout() time.Duration {
+	if ch.Threshold == 0 {
+		return DefaultTimeout
+	}
+	return ch.Threshold
+}
+
 // Web is a helper to implements http.Handler as mux.
 // There was a Handler[RequestType,ResponseType] design,
 // which is good as guaranteed type consistency between its methods,
@@ -126,38 +120,58 @@ func (ch *ClosureHandler) ResponseContentType() string {
 // The best performance strategy could be a code generator, which is complicated to implements.
 // Or just put the dirty transform work together as it was, which causes a lot of redundancy.
 type Web struct {
-	handlers []Handler
+	root *node
 }
 
+// NewWeb panics on handlers on the same Route.
 func NewWeb(handlers ...Handler) *Web {
-	return &Web{handlers: handlers}
+	ret := &Web{root: newNode()}
+	for _, h := range handlers {
+		ret.root.insert(h.Route(), &endpoint{route: h.Route(), handler: h})
+	}
+	return ret
 }
 
-var serverContextCreator = func() (ctx context.Context, cancel context.CancelFunc) {
-	const threshold = 1000 * time.Millisecond
-	cause := fmt.Errorf("handler exceed timeout %v", threshold)
-	return context.WithTimeoutCause(context.Background(), threshold, cause)
+// HandleRaw serves those not a request-response, likes a WebSocket or a stream of Server-Sent Events,
+// which find params by DetachParams on the request context. HEAD is not served for them on GET.
+func (w *Web) HandleRaw(route Route, handler http.Handler) {
+	w.root.insert(route, &endpoint{route: route, raw: handler})
 }
 
-func (w *Web) findHandler(req *http.Request) Handler {
-	// Maybe a Trie when it's more complicated and the performance difference matters.
-	for _, h := range w.handlers {
-		if h.Match(req) {
-			return h
-		}
-	}
-	return nil
+// serverContextCreator creates the ctx for Handler, which keeps values in parent but not its cancellation,
+// so that a handler is not interrupted in the middle by client.
+var serverContextCreator = func(parent context.Context, threshold time.Duration) (ctx context.Context, cancel context.CancelFunc) {
+	cause := fmt.Errorf("handler exceed timeout %v", threshold)
+	return context.WithTimeoutCause(context.WithoutCancel(parent), threshold, cause)
 }
 
-// ServeHTTP implements that in interface.
+// ServeHTTP implements that in interface. It's 404 on no Route on the path, or 405 on none of the method,
+// with methods allowed in header Allow, which an OPTIONS gets in 204 unless handled.
 func (w *Web) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	h := w.findHandler(request)
-	if h == nil {
-		writer.WriteHeader(http.StatusNotAcceptable)
-		slog.Warn("unmatched request", "req", request)
-		_, _ = writer.Write([]byte(fmt.Sprintf("unsupported request on %v %v", request.Method, request.URL)))
+	if request.Method == http.MethodHead {
+		writer = headWriter{ResponseWriter: writer}
+	}
+	m, found := w.root.find(request.Method, request.URL.Path)
+	if !found {
+		if m.allowed == nil {
+			slog.Warn("unmatched request", "req", request)
+			http.Error(writer, fmt.Sprintf("no resource on %v", request.URL.Path), http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Allow", strings.Join(m.allowed, ", "))
+		if request.Method == http.MethodOptions {
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(writer, fmt.Sprintf("method %v not allowed on %v", request.Method, request.URL.Path), http.StatusMethodNotAllowed)
+		return
+	}
+	request = request.WithContext(AttachParams(request.Context(), m.params))
+	if m.endpoint.raw != nil {
+		m.endpoint.raw.ServeHTTP(writer, request)
 		return
 	}
+	h := m.endpoint.handler
 
 	inputData, err := io.ReadAll(request.Body)
 	if err != nil {
@@ -167,7 +181,7 @@ func (w *Web) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	input, err := h.Parse(inputData, request.URL.Path)
+	input, err := h.Parse(inputData, m.params)
 	if err != nil {
 		slog.Warn("bad input format", "err", err, "req", request)
 		writer.WriteHeader(http.StatusBadRequest)
@@ -176,9 +190,20 @@ func (w *Web) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	ctx, cancel := serverContextCreator()
+	threshold := DefaultTimeout
+	if th, ok := h.(TimeoutHandler); ok {
+		threshold = th.Timeout()
+	}
+	ctx, cancel := serverContextCreator(request.Context(), threshold)
 	defer cancel()
-	ctx = AttachToken(ctx, request.Header.Get("Token"))
+	token, err := requestToken(request)
+	if err != nil {
+		// Not refused here, as a stale cookie shall not stop Login.
+		slog.Warn("ignore session cookie", "err", err, "path", request.URL.Path)
+	}
+	ctx = AttachToken(ctx, token)
+	ctx = AttachHeader(ctx, writer.Header())
+	ctx = AttachQuery(ctx, request.URL.Query())
 	output, e := h.Handle(ctx, input)
 	if e != nil {
 		if IsUserFault(e.Code) {
@@ -186,6 +211,10 @@ func (w *Web) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		} else {
 			slog.Error("resp " + e.Error())
 		}
+		if entry := detachAudit(ctx); entry != nil {
+			entry.err = e.Err.Error()
+		}
+		writer.WriteHeader(e.Code)
 		_, _ = writer.Write([]byte(e.Err.Error()))
 		return
 	}
@@ -204,6 +233,15 @@ func IsUserFault(httpStatusCode int) bool {
 	return httpStatusCode/100 == 4
 }
 
+// headerToken finds the token in header Token, or as Bearer in header Authorization.
+func headerToken(request *http.Request) string {
+	if token := request.Header.Get("Token"); token != "" {
+		return token
+	}
+	token, _ := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
 const ctxTokenKey = "token"
 
 func AttachToken(ctx context.Context, token string) context.Context {
@@ -214,13 +252,39 @@ func DetachToken(ctx context.Context) string {
 	return ctx.Value(ctxTokenKey).(string)
 }
 
-type ParseFunc func(data []byte, path string) (req any, err error)
+const ctxHeaderKey = "header"
+
+// AttachHeader carries the response header for those handlers that set cookies, which shall not write the body.
+func AttachHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, ctxHeaderKey, header)
+}
+
+// DetachHeader returns the response header, or nil if not attached.
+func DetachHeader(ctx context.Context) http.Header {
+	ret, _ := ctx.Value(ctxHeaderKey).(http.Header)
+	return ret
+}
+
+const ctxQueryKey = "query"
+
+// AttachQuery carries query parameters for those optional options in Handle, which are not part of the resource.
+func AttachQuery(ctx context.Context, query url.Values) context.Context {
+	return context.WithValue(ctx, ctxQueryKey, query)
+}
+
+func DetachQuery(ctx context.Context) url.Values {
+	ret, _ := ctx.Value(ctxQueryKey).(url.Values)
+	return ret
+}
+
+// ParseFunc parses the body, with params of the Route matched.
+type ParseFunc func(data []byte, params Params) (req any, err error)
 
 func JSONParser(clazz reflect.Type) ParseFunc {
 	if clazz == reflect.TypeOf(Empty{}) {
 		return ParseEmpty
 	}
-	return func(data []byte, _ string) (any, error) {
+	return func(data []byte, _ Params) (any, error) {
 		value := reflect.New(clazz)
 		if err := json.Unmarshal(data, value.Interface()); err != nil {
 			return value, err
@@ -229,23 +293,44 @@ func JSONParser(clazz reflect.Type) ParseFunc {
 	}
 }
 
-func PathIDParser(pathSuffixWithHeadSlashNullable string) ParseFunc {
-	return func(_ []byte, path string) (any, error) {
-		if pathSuffixWithHeadSlashNullable != "" {
-			rest, found := strings.CutSuffix(path, pathSuffixWithHeadSlashNullable)
-			if !found {
-				return 0, fmt.Errorf("no suffix %v in path %v", pathSuffixWithHeadSlashNullable, path)
-			}
-			path = rest
+// ResourceWithName likes ResourceWithID, but on a name without slash instead of a number, as parameter name.
+func ResourceWithName(method string, pathPrefixWithTailSlash string, pathSuffixWithHeadSlashNullable string) Route {
+	return Route{Method: method, Pattern: pathPrefixWithTailSlash + "{name}" + pathSuffixWithHeadSlashNullable}
+}
+
+// ParamIntParser provides the parameter in :int, likes id of ResourceWithID.
+func ParamIntParser(name string) ParseFunc {
+	return func(_ []byte, params Params) (any, error) {
+		return params.Int(name), nil
+	}
+}
+
+// ParamStringParser provides the parameter, likes name of ResourceWithName.
+func ParamStringParser(name string) ParseFunc {
+	return func(_ []byte, params Params) (any, error) {
+		return params.String(name), nil
+	}
+}
+
+// IDWithBody is what IDWithJSONParser provides, the ID from path with the Body parsed by JSONParser.
+type IDWithBody struct {
+	ID   int
+	Body any
+}
+
+// IDWithJSONParser is for ResourceWithID with a body.
+func IDWithJSONParser(clazz reflect.Type) ParseFunc {
+	bodyParser := JSONParser(clazz)
+	return func(data []byte, params Params) (any, error) {
+		body, err := bodyParser(data, params)
+		if err != nil {
+			return nil, err
 		}
-		// The Matcher shall have guaranteed a valid number here. So we can skip validation here.
-		str := path[strings.LastIndexByte(path, '/')+1:]
-		num, _ := strconv.Atoi(str)
-		return num, nil
+		return IDWithBody{ID: params.Int("id"), Body: body}, nil
 	}
 }
 
-func ParseEmpty(_ []byte, _ string) (any, error) {
+func ParseEmpty(_ []byte, _ Params) (any, error) {
 	return nil, nil
 }
 
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+var v1GetGatewayStats = Exact(http.MethodGet, "/v1/gateway/stats")
+
+// SetGatewayStats tells where GetGatewayStats finds those, as the gateway is made after the Service.
+// The stats are in any form to marshal as JSON, likes []gateway.RouteStats, for the gateway is in front of
+// the Service which shall not depend on it.
+func (s *Service) SetGatewayStats(stats func() any) {
+	s.gatewayStats = stats
+}
+
+// GetGatewayStats returns the rejection counts of each gateway route.
+func (s *Service) GetGatewayStats(ctx context.Context) (any, *CodedError) {
+	if _, e := s.authenticate(ctx, "GetGatewayStats", ""); e != nil {
+		return nil, e
+	}
+	if s.gatewayStats == nil {
+		return make([]any, 0), nil
+	}
+	return s.gatewayStats(), nil
+}
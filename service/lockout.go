@@ -0,0 +1,65 @@
+package service
+
+import (
+	"amah/client/application"
+	"amah/client/audit"
+	"amah/client/auth"
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EventLockout is published when a username or an IP is locked out of login, here or on the gateway.
+const EventLockout application.EventType = "lockout"
+
+// acquireLogin reserves an attempt on both the username and the source, which shall end in failLogin,
+// succeedLogin or releaseLogin. It returns an 429 *CodedError with Retry-After if either is locked out.
+func (s *Service) acquireLogin(ctx context.Context, username string, source string, now time.Time) *CodedError {
+	key, retryAt, ok := s.lockout.AcquireLogin(username, source, now)
+	if ok {
+		return nil
+	}
+	if header := DetachHeader(ctx); header != nil {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAt.Sub(now).Seconds()))))
+	}
+	return NewCodedErrorf(http.StatusTooManyRequests, "%s locked out until %s", key, retryAt.Format(time.RFC3339))
+}
+
+// releaseLogin ends the attempt which is neither a failure nor a success.
+func (s *Service) releaseLogin(username string, source string) {
+	s.lockout.ReleaseLogin(username, source)
+}
+
+// failLogin counts the failure, and those locked out by it are recorded by recordLock.
+func (s *Service) failLogin(username string, source string, now time.Time) {
+	s.lockout.FailLogin(username, source, now)
+}
+
+func (s *Service) succeedLogin(username string, source string) {
+	s.lockout.SucceedLogin(username, source)
+}
+
+// recordLock is the auth.Lockout.OnLock, which publishes the lock and appends it to the audit log.
+func (s *Service) recordLock(lock auth.Lock) {
+	message := lock.Key + " until " + lock.Until.Format(time.RFC3339)
+	slog.Warn("login locked out", "key", lock.Key, "until", lock.Until)
+	s.publish(application.Event{Time: lock.Time, Type: EventLockout, Message: message, User: lock.Username})
+	if s.auditLog == nil {
+		return
+	}
+	err := s.auditLog.Append(audit.Record{
+		Time:    lock.Time,
+		User:    lock.Username,
+		Source:  lock.Source,
+		Action:  "Lockout",
+		Target:  lock.Key,
+		Outcome: http.StatusTooManyRequests,
+		Error:   message,
+	})
+	if err != nil {
+		slog.Error("audit append", "err", err, "action", "Lockout", "user", lock.Username)
+	}
+}
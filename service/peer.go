@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Peer is the local process on the other end of a Unix socket, as SO_PEERCRED tells.
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (p Peer) String() string {
+	return fmt.Sprintf("unix:uid=%d,pid=%d", p.UID, p.PID)
+}
+
+// gateway tells whether the peer runs as this process, likes the gateway, whose requests are on behalf of others.
+func (p Peer) gateway() bool {
+	return int(p.UID) == os.Getuid()
+}
+
+const ctxPeerKey = "peer"
+
+// PeerConnContext is for http.Server.ConnContext, which attaches the Peer of a Unix socket connection.
+func PeerConnContext(ctx context.Context, conn net.Conn) context.Context {
+	peer, ok := peerOf(conn)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxPeerKey, peer)
+}
+
+// detachPeer returns false if the request is not on a Unix socket.
+func detachPeer(ctx context.Context) (Peer, bool) {
+	ret, ok := ctx.Value(ctxPeerKey).(Peer)
+	return ret, ok
+}
+
+// AuthenticatePeers lets a local user without token act as the account of the same username,
+// except the one this process runs as, which the gateway does.
+func (s *Service) AuthenticatePeers(enabled bool) {
+	s.peerAuth = enabled
+}
+
+func (s *Service) authenticatePeer(entry *auditEntry, peer Peer, action string, target string) (string, *CodedError) {
+	local, err := user.LookupId(strconv.FormatUint(uint64(peer.UID), 10))
+	if err != nil {
+		return "", NewCodedErrorf(http.StatusForbidden, "no local user on uid %d", peer.UID)
+	}
+	account, ok := s.authClient.FindAccount(local.Username)
+	if !ok || account.Disabled() {
+		return "", NewCodedErrorf(http.StatusForbidden, "no account %s", local.Username)
+	}
+	if entry != nil {
+		entry.user = account.Username
+	}
+	if e := authorize(account, action, target); e != nil {
+		return "", e
+	}
+	if entry != nil && entry.method != http.MethodGet {
+		slog.Info(action, "target", target, "user", account.Username, "peer", peer)
+	}
+	return account.Username, nil
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+func peerOf(conn net.Conn) (Peer, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return Peer{}, false
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return Peer{}, false
+	}
+	return Peer{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, true
+}
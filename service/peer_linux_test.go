@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_peerOf(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "amah.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(listener net.Listener) {
+		_ = listener.Close()
+	}(listener)
+	client, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(client net.Conn) {
+		_ = client.Close()
+	}(client)
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(conn net.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	peer, ok := peerOf(conn)
+	if !ok {
+		t.Fatal("peerOf() not ok")
+	}
+	if int(peer.UID) != os.Getuid() || int(peer.PID) != os.Getpid() {
+		t.Errorf("peerOf() = %+v, want uid %d pid %d", peer, os.Getuid(), os.Getpid())
+	}
+	if !peer.gateway() {
+		t.Error("gateway() of this process false")
+	}
+	if _, ok := peerOf(&net.TCPConn{}); ok {
+		t.Error("peerOf() on TCP ok")
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/processes", nil)
+	request = request.WithContext(PeerConnContext(request.Context(), conn))
+	if got := sourceIP(request); got != peer.String() {
+		t.Errorf("sourceIP() = %v, want %v", got, peer.String())
+	}
+	request.Header.Set("X-Forwarded-For", "10.0.0.1")
+	if got := sourceIP(request); got != "10.0.0.1" {
+		t.Errorf("sourceIP() forwarded by gateway = %v, want 10.0.0.1", got)
+	}
+}
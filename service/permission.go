@@ -0,0 +1,81 @@
+package service
+
+import (
+	"amah/client/auth"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// permission is what an action requires.
+type permission struct {
+	role      auth.Role
+	scope     auth.Scope // for auth.APIKey, empty means no key could
+	appScoped bool       // the target is an app ID, which shall be in AppIDs of auth.Account or auth.APIKey
+}
+
+// actionToPermission are those actions through authenticate, any one absent requires auth.RoleAdmin and no key could.
+var actionToPermission = map[string]permission{
+	"GetProcesses":         {auth.RoleViewer, auth.ScopeRead, false},
+	"GetApplications":      {auth.RoleViewer, auth.ScopeRead, false},
+	"GetEvents":            {auth.RoleViewer, auth.ScopeRead, false},
+	"GetApplicationOutput": {auth.RoleViewer, auth.ScopeRead, true},
+	"GetApplicationEvents": {auth.RoleViewer, auth.ScopeRead, true},
+	"StartApplication":     {auth.RoleOperator, auth.ScopeStart, true},
+	"InputApplication":     {auth.RoleOperator, "", true},
+	"AttachTerminal":       {auth.RoleOperator, "", true},
+	"DeleteProcess":        {auth.RoleAdmin, auth.ScopeStop, false},
+	"ReloadAppConfig":      {auth.RoleAdmin, auth.ScopeDeploy, false},
+	"GetAudit":             {auth.RoleAdmin, "", false},
+	"Logout":               {auth.RoleViewer, "", false},
+	"ChangePassword":       {auth.RoleViewer, "", false},
+}
+
+// authorize returns an 403 *CodedError if the account can not do the action on target.
+func authorize(account auth.Account, action string, target string) *CodedError {
+	p, ok := actionToPermission[action]
+	if !ok {
+		p = permission{role: auth.RoleAdmin}
+	}
+	if !account.Role.Covers(p.role) {
+		return NewCodedErrorf(http.StatusForbidden, "%s as %s can not %s", account.Username, account.Role, action)
+	}
+	if p.appScoped {
+		appID, err := strconv.Atoi(target)
+		if err != nil || !account.Permits(appID) {
+			return NewCodedErrorf(http.StatusForbidden, "%s can not %s on app %s", account.Username, action, target)
+		}
+	}
+	return nil
+}
+
+// authorizeKey returns an 403 *CodedError if the key can not do the action on target.
+func authorizeKey(key auth.APIKey, action string, target string) *CodedError {
+	p := actionToPermission[action]
+	if p.scope == "" || !key.Has(p.scope) {
+		return NewCodedErrorf(http.StatusForbidden, "key %s has no scope to %s", key.Name, action)
+	}
+	if p.appScoped {
+		appID, err := strconv.Atoi(target)
+		if err != nil || !key.Permits(appID) {
+			return NewCodedErrorf(http.StatusForbidden, "key %s can not %s on app %s", key.Name, action, target)
+		}
+	} else if len(key.AppIDs) > 0 && p.scope != auth.ScopeRead {
+		// Likes kill a process, which could be of any app.
+		return NewCodedErrorf(http.StatusForbidden, "key %s limited to apps can not %s", key.Name, action)
+	}
+	return nil
+}
+
+// keyUserPrefix marks the username of an auth.APIKey, to tell it from an account in audit and events.
+const keyUserPrefix = "key:"
+
+// permittedApps returns which apps the user could see, nil means all.
+func (s *Service) permittedApps(username string) []int {
+	if name, ok := strings.CutPrefix(username, keyUserPrefix); ok {
+		key, _ := s.keyStore.Find(name)
+		return key.AppIDs
+	}
+	account, _ := s.authClient.FindAccount(username)
+	return account.AppIDs
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"amah/client/auth"
+	"testing"
+)
+
+func Test_authorize(t *testing.T) {
+	viewer := auth.Account{Username: "v", Role: auth.RoleViewer}
+	operator := auth.Account{Username: "o", Role: auth.RoleOperator, AppIDs: []int{1001}}
+	admin := auth.Account{Username: "a", Role: auth.RoleAdmin}
+	tests := []struct {
+		name    string
+		account auth.Account
+		action  string
+		target  string
+		wantErr bool
+	}{
+		{"viewer reads", viewer, "GetApplicationOutput", "1001", false},
+		{"viewer starts", viewer, "StartApplication", "1001", true},
+		{"operator starts permitted", operator, "StartApplication", "1001", false},
+		{"operator starts other", operator, "StartApplication", "1002", true},
+		{"operator kills", operator, "DeleteProcess", "42", true},
+		{"admin kills", admin, "DeleteProcess", "42", false},
+		{"admin unknown action", admin, "Whatever", "", false},
+		{"operator unknown action", operator, "Whatever", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authorize(tt.account, tt.action, tt.target); (got != nil) != tt.wantErr {
+				t.Errorf("authorize() = %v, wantErr %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authorizeKey(t *testing.T) {
+	reader := auth.APIKey{Name: "r", Scopes: []auth.Scope{auth.ScopeRead}}
+	deployer := auth.APIKey{Name: "d", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeStart, auth.ScopeStop}, AppIDs: []int{1001}}
+	tests := []struct {
+		name    string
+		key     auth.APIKey
+		action  string
+		target  string
+		wantErr bool
+	}{
+		{"read", reader, "GetApplications", "", false},
+		{"read no start", reader, "StartApplication", "1001", true},
+		{"start permitted", deployer, "StartApplication", "1001", false},
+		{"start other", deployer, "StartApplication", "1002", true},
+		{"stop limited to apps", deployer, "DeleteProcess", "42", true},
+		{"no scope for input", deployer, "InputApplication", "1001", true},
+		{"no scope for keys", deployer, "CreateKey", "ci", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authorizeKey(tt.key, tt.action, tt.target); (got != nil) != tt.wantErr {
+				t.Errorf("authorizeKey() = %v, wantErr %v", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Route is a method with a path pattern, whose segments in braces are named parameters,
+// likes /v1/applications/{id:int}/runs/{run}. A parameter is a whole segment, not empty,
+// and only an integer with :int.
+type Route struct {
+	Method  string
+	Pattern string
+}
+
+func (r Route) String() string {
+	return r.Method + " " + r.Pattern
+}
+
+const intParamSuffix = ":int"
+
+// parseSegment returns the name of a parameter segment, with false for a static one.
+func parseSegment(segment string) (name string, isInt bool, isParam bool) {
+	inner, found := strings.CutPrefix(segment, "{")
+	if !found {
+		return "", false, false
+	}
+	inner, found = strings.CutSuffix(inner, "}")
+	if !found {
+		panic(fmt.Sprintf("bad path segment %s", segment))
+	}
+	name, isInt = strings.CutSuffix(inner, intParamSuffix)
+	return name, isInt, true
+}
+
+// Params are the values of named parameters in the Route matched.
+type Params map[string]string
+
+func (p Params) String(name string) string {
+	return p[name]
+}
+
+// Int returns the value of a parameter in :int, which the Route has guaranteed a valid number.
+func (p Params) Int(name string) int {
+	ret, _ := strconv.Atoi(p[name])
+	return ret
+}
+
+const ctxParamsKey = "params"
+
+func AttachParams(ctx context.Context, params Params) context.Context {
+	return context.WithValue(ctx, ctxParamsKey, params)
+}
+
+// DetachParams returns the params of the Route matched, or nil if not through Web.
+func DetachParams(ctx context.Context) Params {
+	ret, _ := ctx.Value(ctxParamsKey).(Params)
+	return ret
+}
+
+// endpoint is a Handler, or a raw http.Handler for those not a request-response, on a Route.
+type endpoint struct {
+	route   Route
+	handler Handler
+	raw     http.Handler
+	names   []string // of parameters in order, which differ between routes sharing a node
+}
+
+// node is of a trie on path segments. A segment goes static first, then an int parameter, then any parameter.
+type node struct {
+	static   map[string]*node
+	intParam *node
+	strParam *node
+	methods  map[string]*endpoint
+}
+
+func newNode() *node {
+	return &node{
+		static:   make(map[string]*node),
+		intParam: nil,
+		strParam: nil,
+		methods:  make(map[string]*endpoint),
+	}
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// insert panics on a route registered twice, likes http.ServeMux, as it's a mistake in code.
+func (n *node) insert(r Route, e *endpoint) {
+	current := n
+	for _, segment := range splitPath(r.Pattern) {
+		name, isInt, isParam := parseSegment(segment)
+		var next **node
+		switch {
+		case !isParam:
+			child, ok := current.static[segment]
+			if !ok {
+				child = newNode()
+				current.static[segment] = child
+			}
+			current = child
+			continue
+		case isInt:
+			next = &current.intParam
+		default:
+			next = &current.strParam
+		}
+		if *next == nil {
+			*next = newNode()
+		}
+		current = *next
+		e.names = append(e.names, name)
+	}
+	if old, ok := current.methods[r.Method]; ok {
+		panic(fmt.Sprintf("route %v conflicts with %v", r, old.route))
+	}
+	current.methods[r.Method] = e
+}
+
+// walk visits nodes matching segments with methods in priority, until visit returns true.
+func (n *node) walk(segments []string, values []string, visit func(*node, []string) bool) bool {
+	if len(segments) == 0 {
+		return len(n.methods) > 0 && visit(n, values)
+	}
+	segment, rest := segments[0], segments[1:]
+	if child, ok := n.static[segment]; ok && child.walk(rest, values, visit) {
+		return true
+	}
+	if segment == "" {
+		return false
+	}
+	if n.intParam != nil {
+		if _, err := strconv.Atoi(segment); err == nil && n.intParam.walk(rest, append(values, segment), visit) {
+			return true
+		}
+	}
+	return n.strParam != nil && n.strParam.walk(rest, append(values, segment), visit)
+}
+
+// lookup returns the endpoint of method, or GET for HEAD, with head true then.
+func (n *node) lookup(method string) (e *endpoint, head bool) {
+	if e, ok := n.methods[method]; ok {
+		return e, false
+	}
+	if e, ok := n.methods[http.MethodGet]; ok && method == http.MethodHead && e.raw == nil {
+		return e, true
+	}
+	return nil, false
+}
+
+// allowed lists methods on the node, along with HEAD and OPTIONS which Web handles for it.
+func (n *node) allowed() []string {
+	var ret []string
+	for method := range n.methods {
+		ret = append(ret, method)
+	}
+	if _, head := n.lookup(http.MethodHead); head {
+		ret = append(ret, http.MethodHead)
+	}
+	return append(ret, http.MethodOptions)
+}
+
+// match is what find returns, either an endpoint with params, or methods allowed on the path if none for the method.
+type match struct {
+	endpoint *endpoint
+	params   Params
+	allowed  []string // sorted, nil means no route on the path
+}
+
+func (n *node) find(method string, path string) (match, bool) {
+	var ret match
+	var allowed []string
+	n.walk(splitPath(path), nil, func(found *node, values []string) bool {
+		e, _ := found.lookup(method)
+		if e == nil {
+			allowed = append(allowed, found.allowed()...)
+			return false
+		}
+		params := make(Params, len(values))
+		for i, name := range e.names {
+			params[name] = values[i]
+		}
+		ret = match{endpoint: e, params: params}
+		return true
+	})
+	if ret.endpoint != nil {
+		return ret, true
+	}
+	if allowed != nil {
+		slices.Sort(allowed)
+		ret.allowed = slices.Compact(allowed)
+	}
+	return ret, false
+}
+
+// headWriter drops the body on HEAD, likes for a GET handler.
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
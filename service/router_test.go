@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_node_find(t *testing.T) {
+	root := newNode()
+	for _, r := range []Route{
+		Exact(http.MethodGet, "/v1/applications"),
+		ResourceWithID(http.MethodGet, "/v1/applications/", "/output"),
+		ResourceWithID(http.MethodPut, "/v1/applications/", ""),
+		{http.MethodGet, "/v1/applications/{app:int}/runs/{run}"},
+		{http.MethodGet, "/v1/applications/{name}/runs/latest"},
+		ResourceWithName(http.MethodDelete, "/v1/keys/", ""),
+		Exact(http.MethodGet, "/v1/keys/stats"),
+		Exact(http.MethodGet, "/"),
+	} {
+		root.insert(r, &endpoint{route: r})
+	}
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		want        Route
+		wantParams  Params
+		wantAllowed []string
+	}{
+		{"exact", http.MethodGet, "/v1/applications", Exact(http.MethodGet, "/v1/applications"), Params{}, nil},
+		{"root", http.MethodGet, "/", Exact(http.MethodGet, "/"), Params{}, nil},
+		{"id", http.MethodGet, "/v1/applications/1001/output", ResourceWithID(http.MethodGet, "/v1/applications/", "/output"),
+			Params{"id": "1001"}, nil},
+		{"params", http.MethodGet, "/v1/applications/1001/runs/r7", Route{http.MethodGet, "/v1/applications/{app:int}/runs/{run}"},
+			Params{"app": "1001", "run": "r7"}, nil},
+		{"int before string", http.MethodGet, "/v1/applications/1001/runs/latest", Route{http.MethodGet, "/v1/applications/{app:int}/runs/{run}"},
+			Params{"app": "1001", "run": "latest"}, nil},
+		{"string on not int", http.MethodGet, "/v1/applications/web/runs/latest", Route{http.MethodGet, "/v1/applications/{name}/runs/latest"},
+			Params{"name": "web"}, nil},
+		{"static before param", http.MethodGet, "/v1/keys/stats", Exact(http.MethodGet, "/v1/keys/stats"), Params{}, nil},
+		{"param on method of static", http.MethodDelete, "/v1/keys/stats", ResourceWithName(http.MethodDelete, "/v1/keys/", ""),
+			Params{"name": "stats"}, nil},
+		{"head", http.MethodHead, "/v1/applications", Exact(http.MethodGet, "/v1/applications"), Params{}, nil},
+		{"not allowed", http.MethodPost, "/v1/applications/1001", Route{}, nil, []string{"OPTIONS", "PUT"}},
+		{"allowed of all matched", http.MethodPost, "/v1/keys/stats", Route{}, nil, []string{"DELETE", "GET", "HEAD", "OPTIONS"}},
+		{"not int", http.MethodPut, "/v1/applications/web", Route{}, nil, nil},
+		{"not found", http.MethodGet, "/v1/nothing", Route{}, nil, nil},
+		{"tail slash", http.MethodGet, "/v1/applications/", Route{}, nil, nil},
+		{"empty param", http.MethodDelete, "/v1/keys/", Route{}, nil, nil},
+		{"longer", http.MethodGet, "/v1/applications/1001/output/more", Route{}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := root.find(tt.method, tt.path)
+			if found != (tt.want != Route{}) {
+				t.Fatalf("find() found = %v, want %v", found, tt.want)
+			}
+			if found {
+				if got.endpoint.route != tt.want || !reflect.DeepEqual(got.params, tt.wantParams) {
+					t.Errorf("find() = %v %v, want %v %v", got.endpoint.route, got.params, tt.want, tt.wantParams)
+				}
+			}
+			if !reflect.DeepEqual(got.allowed, tt.wantAllowed) {
+				t.Errorf("find() allowed = %v, want %v", got.allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func Test_node_insert(t *testing.T) {
+	root := newNode()
+	root.insert(ResourceWithID(http.MethodGet, "/v1/applications/", ""), &endpoint{})
+	defer func() {
+		if recover() == nil {
+			t.Error("insert() twice no panic")
+		}
+	}()
+	root.insert(Route{http.MethodGet, "/v1/applications/{app:int}"}, &endpoint{})
+}
+
+func TestWeb_ServeHTTP(t *testing.T) {
+	web := NewWeb(
+		NewJSONHandler(
+			Route{http.MethodGet, "/v1/applications/{id:int}/runs/{run}"},
+			reflect.TypeOf(Empty{}),
+			func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+				params := DetachParams(ctx)
+				return []any{params.Int("id"), params.String("run")}, nil
+			},
+		),
+		&ClosureHandler{
+			Matcher: ResourceWithName(http.MethodGet, "/v1/users/", "/runs"),
+			Parser:  ParamStringParser("name"),
+			Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+				return req, nil
+			},
+			Formatter:   json.Marshal,
+			ContentType: JSONContentType,
+		},
+	)
+	web.HandleRaw(Exact(http.MethodGet, "/v1/events"), http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("raw"))
+	}))
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		want      int
+		wantBody  string
+		wantAllow string
+	}{
+		{"params", http.MethodGet, "/v1/applications/1001/runs/r7", http.StatusOK, `[1001,"r7"]`, ""},
+		{"parsed param", http.MethodGet, "/v1/users/alice/runs", http.StatusOK, `"alice"`, ""},
+		{"head", http.MethodHead, "/v1/applications/1001/runs/r7", http.StatusOK, "", ""},
+		{"options", http.MethodOptions, "/v1/applications/1001/runs/r7", http.StatusNoContent, "", "GET, HEAD, OPTIONS"},
+		{"not allowed", http.MethodDelete, "/v1/applications/1001/runs/r7", http.StatusMethodNotAllowed,
+			"method DELETE not allowed on /v1/applications/1001/runs/r7\n", "GET, HEAD, OPTIONS"},
+		{"not found", http.MethodGet, "/v1/applications/web/runs/r7", http.StatusNotFound, "no resource on /v1/applications/web/runs/r7\n", ""},
+		{"raw", http.MethodGet, "/v1/events", http.StatusOK, "raw", ""},
+		{"raw no head", http.MethodHead, "/v1/events", http.StatusMethodNotAllowed, "", "GET, OPTIONS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			web.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+			if recorder.Code != tt.want {
+				t.Errorf("ServeHTTP() code = %v, want %v", recorder.Code, tt.want)
+			}
+			if tt.method != http.MethodHead && recorder.Body.String() != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+			if tt.method == http.MethodHead && recorder.Body.Len() != 0 {
+				t.Errorf("ServeHTTP() body on HEAD = %q", recorder.Body.String())
+			}
+			if got := recorder.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("ServeHTTP() Allow = %v, want %v", got, tt.wantAllow)
+			}
+		})
+	}
+}
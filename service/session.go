@@ -0,0 +1,71 @@
+package service
+
+import (
+	"amah/client/audit"
+	"context"
+	"net/http"
+	"time"
+)
+
+var (
+	v1DeleteSession      = Exact(http.MethodDelete, "/v1/session")
+	v1GetSessions        = Exact(http.MethodGet, "/v1/sessions")
+	v1DeleteOtherSession = ResourceWithName(http.MethodDelete, "/v1/sessions/", "")
+)
+
+// Session is an auth.Token without its ID, which is a credential.
+// The ID here is the digest of it, same as TokenID in audit.Record.
+type Session struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpireAt   time.Time `json:"expireAt"`
+	Source     string    `json:"source"`
+}
+
+// Logout ends the session of the token in ctx.
+func (s *Service) Logout(ctx context.Context) *CodedError {
+	if _, e := s.authenticate(ctx, "Logout", ""); e != nil {
+		return e
+	}
+	if _, err := s.authClient.RevokeToken(DetachToken(ctx)); err != nil {
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+	clearSessionCookies(ctx)
+	return nil
+}
+
+func (s *Service) GetSessions(ctx context.Context) ([]Session, *CodedError) {
+	if _, e := s.authenticate(ctx, "GetSessions", ""); e != nil {
+		return nil, e
+	}
+	ret := make([]Session, 0)
+	for _, t := range s.authClient.Tokens() {
+		ret = append(ret, Session{
+			ID:         audit.DigestTokenHash(t.Hash),
+			Username:   t.Username,
+			CreatedAt:  t.CreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpireAt:   t.ExpireAt,
+			Source:     t.Source,
+		})
+	}
+	return ret, nil
+}
+
+// RevokeSession ends the session on id from GetSessions.
+func (s *Service) RevokeSession(ctx context.Context, id string) *CodedError {
+	if _, e := s.authenticate(ctx, "RevokeSession", id); e != nil {
+		return e
+	}
+	for _, t := range s.authClient.Tokens() {
+		if audit.DigestTokenHash(t.Hash) == id {
+			if _, err := s.authClient.RevokeTokenByHash(t.Hash); err != nil {
+				return NewCodedError(http.StatusInternalServerError, err)
+			}
+			return nil
+		}
+	}
+	return NewCodedErrorf(http.StatusNotFound, "no session %s", id)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"amah/client/application"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const v1GetApplicationTerminalSuffix = "/terminal"
+
+var v1GetApplicationTerminal = ResourceWithID(http.MethodGet, "/v1/applications/", v1GetApplicationTerminalSuffix)
+
+// TerminalResize is the text message from client to resize the terminal.
+// Any binary message from client is keystrokes, and any from server is output.
+type TerminalResize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// Default CheckOrigin rejects cross-origin requests, which is what we want as the browser sends the session cookie.
+var upgrader = websocket.Upgrader{}
+
+// AttachTerminal upgrades the request to a WebSocket that attaches to the terminal of the app.
+// As browsers can not set header on WebSocket, they authenticate by the session cookie.
+func (s *Service) AttachTerminal(writer http.ResponseWriter, request *http.Request) {
+	appID := DetachParams(request.Context()).Int("id")
+
+	ctx := AttachToken(request.Context(), tokenOf(request))
+	if _, e := s.authenticate(ctx, "AttachTerminal", strconv.Itoa(appID)); e != nil {
+		slog.Warn("resp " + e.Error())
+		http.Error(writer, e.Err.Error(), e.Code)
+		return
+	}
+
+	app, ok := s.findClient(appID)
+	if !ok {
+		http.Error(writer, "app on not exists id "+strconv.Itoa(appID), http.StatusNotFound)
+		return
+	}
+	output, detach, err := app.Attach()
+	if err != nil {
+		code := http.StatusServiceUnavailable
+		if errors.Is(err, application.ErrNoTerminal) || errors.Is(err, application.ErrTerminated) {
+			code = http.StatusConflict
+		}
+		http.Error(writer, err.Error(), code)
+		return
+	}
+	defer detach()
+
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		// Upgrade has replied the client with an HTTP error.
+		slog.Warn("terminal upgrade", "err", err)
+		return
+	}
+	defer func(conn *websocket.Conn) {
+		_ = conn.Close()
+	}(conn)
+
+	go func() {
+		for chunk := range output {
+			if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+				slog.Warn("terminal write", "appID", appID, "err", err)
+				break
+			}
+		}
+		// Either app exits, the client is terminated or gone, close to make the read loop below ends.
+		message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "terminal ends")
+		_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		_ = conn.Close()
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch messageType {
+		case websocket.BinaryMessage:
+			err = app.WriteTerminal(data)
+		case websocket.TextMessage:
+			var resize TerminalResize
+			if err = json.Unmarshal(data, &resize); err == nil {
+				err = app.Resize(resize.Rows, resize.Cols)
+			}
+		}
+		if err != nil {
+			slog.Warn("terminal read", "appID", appID, "err", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"amah/client/auth"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	v1UserDisableSuffix = "/disable"
+	v1UserEnableSuffix  = "/enable"
+)
+
+var (
+	v1PostUser       = Exact(http.MethodPost, "/v1/users")
+	v1GetUsers       = Exact(http.MethodGet, "/v1/users")
+	v1DeleteUser     = ResourceWithName(http.MethodDelete, "/v1/users/", "")
+	v1PutUserDisable = ResourceWithName(http.MethodPut, "/v1/users/", v1UserDisableSuffix)
+	v1PutUserEnable  = ResourceWithName(http.MethodPut, "/v1/users/", v1UserEnableSuffix)
+	v1PutPassword    = Exact(http.MethodPut, "/v1/password")
+)
+
+type UserInfo struct {
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Role     auth.Role `json:"role"`
+	AppIDs   []int     `json:"appIDs"`
+}
+
+// String redacts the password, likes LoginInfo.
+func (ui UserInfo) String() string {
+	return fmt.Sprintf("{%s *** %s %v}", ui.Username, ui.Role, ui.AppIDs)
+}
+
+// User is an auth.Account without those secrets.
+type User struct {
+	Username string    `json:"username"`
+	Role     auth.Role `json:"role"`
+	AppIDs   []int     `json:"appIDs"`
+	Disabled bool      `json:"disabled"`
+	TOTP     bool      `json:"totp"`
+}
+
+type PasswordInfo struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// String redacts both passwords, likes LoginInfo.
+func (pi PasswordInfo) String() string {
+	return "{*** ***}"
+}
+
+// accountError maps errors of account management in auth.Client.
+func accountError(err error) *CodedError {
+	switch {
+	case errors.Is(err, auth.ErrNoAccount):
+		return NewCodedError(http.StatusNotFound, err)
+	case errors.Is(err, auth.ErrDuplicatedUsername), errors.Is(err, auth.ErrLastAdmin):
+		return NewCodedError(http.StatusConflict, err)
+	case errors.Is(err, auth.ErrBadUsername):
+		return NewCodedError(http.StatusBadRequest, err)
+	default:
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Service) CreateUser(ctx context.Context, info *UserInfo) *CodedError {
+	if _, e := s.authenticate(ctx, "CreateUser", info.Username); e != nil {
+		return e
+	}
+	if info.Password == "" {
+		return NewCodedErrorf(http.StatusBadRequest, "no password")
+	}
+	role, err := auth.ParseRole(string(info.Role))
+	if err != nil {
+		return NewCodedError(http.StatusBadRequest, err)
+	}
+	if err := s.authClient.AddAccount(info.Username, info.Password, role, info.AppIDs); err != nil {
+		return accountError(err)
+	}
+	return nil
+}
+
+func (s *Service) GetUsers(ctx context.Context) ([]User, *CodedError) {
+	if _, e := s.authenticate(ctx, "GetUsers", ""); e != nil {
+		return nil, e
+	}
+	ret := make([]User, 0)
+	for _, account := range s.authClient.Accounts() {
+		ret = append(ret, User{
+			Username: account.Username,
+			Role:     account.Role,
+			AppIDs:   account.AppIDs,
+			Disabled: account.Disabled(),
+			TOTP:     account.TOTPSecret != "",
+		})
+	}
+	return ret, nil
+}
+
+func (s *Service) DeleteUser(ctx context.Context, username string) *CodedError {
+	if _, e := s.authenticate(ctx, "DeleteUser", username); e != nil {
+		return e
+	}
+	if err := s.authClient.DeleteAccount(username); err != nil {
+		return accountError(err)
+	}
+	return nil
+}
+
+func (s *Service) SetUserDisabled(ctx context.Context, username string, disabled bool) *CodedError {
+	action := "EnableUser"
+	if disabled {
+		action = "DisableUser"
+	}
+	if _, e := s.authenticate(ctx, action, username); e != nil {
+		return e
+	}
+	if err := s.authClient.SetDisabled(username, disabled); err != nil {
+		return accountError(err)
+	}
+	return nil
+}
+
+// ChangePassword is for the user itself, who shall know the old one, which is checked as Login on lockout.
+// Other sessions of the user end, in case the change is for one stolen.
+func (s *Service) ChangePassword(ctx context.Context, info *PasswordInfo) *CodedError {
+	username, e := s.authenticate(ctx, "ChangePassword", "")
+	if e != nil {
+		return e
+	}
+	if info.NewPassword == "" {
+		return NewCodedErrorf(http.StatusBadRequest, "no new password")
+	}
+	var source string
+	if entry := detachAudit(ctx); entry != nil {
+		source = entry.source
+	}
+	now := time.Now()
+	if e := s.acquireLogin(ctx, username, source, now); e != nil {
+		return e
+	}
+	ok, err := s.authClient.Auth(username, info.OldPassword)
+	if err != nil {
+		s.releaseLogin(username, source)
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		s.failLogin(username, source, now)
+		return NewCodedErrorf(http.StatusForbidden, "bad old password on username[%s]", username)
+	}
+	s.succeedLogin(username, source)
+	if err := s.authClient.SetPassword(username, info.NewPassword); err != nil {
+		return accountError(err)
+	}
+	if _, err := s.authClient.RevokeOtherTokens(username, DetachToken(ctx)); err != nil {
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
@@ -2,39 +2,70 @@ package service
 
 import (
 	"amah/client/application"
+	"amah/client/audit"
 	"amah/client/auth"
 	"amah/client/monitor"
+	"amah/client/notifier"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Service struct {
 	authClient            *auth.Client
+	keyStore              *auth.KeyStore
 	monitorClient         *monitor.Client
 	applicationRepository *application.Repository
+	notifierClient        *notifier.Client
+	bus                   *Bus
+	auditLog              *audit.Log // nullable, nil means no audit
+	lockout               *auth.Lockout
+	gatewaySecret         string     // see TrustGateway
+	peerAuth              bool       // see AuthenticatePeers
+	gatewayStats          func() any // nullable, see SetGatewayStats
 	appIDToClients        map[int]*application.Client
-	mu                    sync.Mutex // guard actions likes exec with scan that shall escape race condition
+	clientsMu             sync.RWMutex // guard appIDToClients
+	mu                    sync.Mutex   // guard actions likes exec with scan that shall escape race condition
 	web                   *Web
 }
 
 func New(
 	authClient *auth.Client,
+	keyStore *auth.KeyStore,
 	monitorClient *monitor.Client,
 	applicationRepository *application.Repository,
+	notifierClient *notifier.Client,
+	auditLog *audit.Log,
+	lockout *auth.Lockout,
 ) *Service {
 	ret := &Service{
 		authClient:            authClient,
+		keyStore:              keyStore,
 		monitorClient:         monitorClient,
 		applicationRepository: applicationRepository,
+		notifierClient:        notifierClient,
+		bus:                   NewBus(1000),
+		auditLog:              auditLog,
+		lockout:               lockout,
+		gatewaySecret:         "",
+		peerAuth:              false,
+		gatewayStats:          nil,
 		appIDToClients:        make(map[int]*application.Client),
+		clientsMu:             sync.RWMutex{},
 		mu:                    sync.Mutex{},
 		web:                   nil,
 	}
+	// So are those on the gateway, which shares the lockout.
+	lockout.OnLock(ret.recordLock)
 	v1PostSession := NewJSONHandler(
 		Exact(http.MethodPost, "/v1/session"),
 		reflect.TypeOf(LoginInfo{}),
@@ -51,7 +82,7 @@ func New(
 	)
 	v1DeleteProcess := &ClosureHandler{
 		Matcher: ResourceWithID(http.MethodDelete, "/v1/processes/", ""),
-		Parser:  PathIDParser(""),
+		Parser:  ParamIntParser("id"),
 		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
 			return nil, ret.DeleteProcess(ctx, req.(int))
 		},
@@ -68,7 +99,7 @@ func New(
 	const v1PutApplicationPathSuffix = "/instances"
 	v1PutApplication := &ClosureHandler{
 		Matcher: ResourceWithID(http.MethodPut, "/v1/applications/", v1PutApplicationPathSuffix),
-		Parser:  PathIDParser(v1PutApplicationPathSuffix),
+		Parser:  ParamIntParser("id"),
 		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
 			return ret.StartApplication(ctx, req.(int))
 		},
@@ -84,13 +115,148 @@ func New(
 	const v1GetApplicationOutputSuffix = "/output"
 	v1GetApplicationOutput := &ClosureHandler{
 		Matcher: ResourceWithID(http.MethodGet, "/v1/applications/", v1GetApplicationOutputSuffix),
-		Parser:  PathIDParser(v1GetApplicationOutputSuffix),
+		Parser:  ParamIntParser("id"),
 		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
 			return ret.GetApplicationOutput(ctx, req.(int))
 		},
 		Formatter:   json.Marshal,
 		ContentType: JSONContentType,
 	}
+	const v1PostApplicationInputSuffix = "/input"
+	v1PostApplicationInput := &ClosureHandler{
+		Matcher: ResourceWithID(http.MethodPost, "/v1/applications/", v1PostApplicationInputSuffix),
+		Parser:  IDWithJSONParser(reflect.TypeOf(InputInfo{})),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			r := req.(IDWithBody)
+			return nil, ret.InputApplication(ctx, r.ID, r.Body.(*InputInfo))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	const v1GetApplicationEventsSuffix = "/events"
+	v1GetApplicationEvents := &ClosureHandler{
+		Matcher: ResourceWithID(http.MethodGet, "/v1/applications/", v1GetApplicationEventsSuffix),
+		Parser:  ParamIntParser("id"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetApplicationEvents(ctx, req.(int))
+		},
+		Formatter:   json.Marshal,
+		ContentType: JSONContentType,
+	}
+	v1GetAuditHandler := NewJSONHandler(
+		v1GetAudit,
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetAudit(ctx)
+		},
+	)
+	v1PostKeyHandler := NewJSONHandler(
+		v1PostKey,
+		reflect.TypeOf(KeyInfo{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.CreateKey(ctx, req.(*KeyInfo))
+		},
+	)
+	v1GetKeysHandler := NewJSONHandler(
+		v1GetKeys,
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetKeys(ctx)
+		},
+	)
+	v1DeleteKeyHandler := &ClosureHandler{
+		Matcher: v1DeleteKey,
+		Parser:  ParamStringParser("name"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.DeleteKey(ctx, req.(string))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1DeleteSessionHandler := &ClosureHandler{
+		Matcher: v1DeleteSession,
+		Parser:  ParseEmpty,
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.Logout(ctx)
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1GetSessionsHandler := NewJSONHandler(
+		v1GetSessions,
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetSessions(ctx)
+		},
+	)
+	v1GetGatewayStatsHandler := NewJSONHandler(
+		v1GetGatewayStats,
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetGatewayStats(ctx)
+		},
+	)
+	v1DeleteOtherSessionHandler := &ClosureHandler{
+		Matcher: v1DeleteOtherSession,
+		Parser:  ParamStringParser("name"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.RevokeSession(ctx, req.(string))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1PostUserHandler := &ClosureHandler{
+		Matcher: v1PostUser,
+		Parser:  JSONParser(reflect.TypeOf(UserInfo{})),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.CreateUser(ctx, req.(*UserInfo))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1GetUsersHandler := NewJSONHandler(
+		v1GetUsers,
+		reflect.TypeOf(Empty{}),
+		func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return ret.GetUsers(ctx)
+		},
+	)
+	v1DeleteUserHandler := &ClosureHandler{
+		Matcher: v1DeleteUser,
+		Parser:  ParamStringParser("name"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.DeleteUser(ctx, req.(string))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1PutUserDisableHandler := &ClosureHandler{
+		Matcher: v1PutUserDisable,
+		Parser:  ParamStringParser("name"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.SetUserDisabled(ctx, req.(string), true)
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1PutUserEnableHandler := &ClosureHandler{
+		Matcher: v1PutUserEnable,
+		Parser:  ParamStringParser("name"),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.SetUserDisabled(ctx, req.(string), false)
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
+	v1PutPasswordHandler := &ClosureHandler{
+		Matcher: v1PutPassword,
+		Parser:  JSONParser(reflect.TypeOf(PasswordInfo{})),
+		Handler: func(ctx context.Context, req any) (rsp any, codedError *CodedError) {
+			return nil, ret.ChangePassword(ctx, req.(*PasswordInfo))
+		},
+		Formatter:   FormatEmpty,
+		ContentType: http.DetectContentType(nil),
+	}
 	ret.web = NewWeb(
 		v1PostSession,
 		v1GetProcesses,
@@ -99,49 +265,190 @@ func New(
 		v1PutApplication,
 		v1PutDashboardAppConfigReload,
 		v1GetApplicationOutput,
+		v1PostApplicationInput,
+		v1GetApplicationEvents,
+		v1GetAuditHandler,
+		v1PostKeyHandler,
+		v1GetKeysHandler,
+		v1DeleteKeyHandler,
+		v1DeleteSessionHandler,
+		v1GetSessionsHandler,
+		v1DeleteOtherSessionHandler,
+		v1PostUserHandler,
+		v1GetUsersHandler,
+		v1DeleteUserHandler,
+		v1PutUserDisableHandler,
+		v1PutUserEnableHandler,
+		v1PutPasswordHandler,
+		v1GetGatewayStatsHandler,
 	)
+	// A WebSocket is not a request-response, so it can not fit in Handler.
+	ret.web.HandleRaw(v1GetApplicationTerminal, http.HandlerFunc(ret.AttachTerminal))
+	// So is a stream of Server-Sent Events.
+	ret.web.HandleRaw(v1GetEvents, http.HandlerFunc(ret.GetEvents))
 	return ret
 }
 
 type LoginInfo struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	OTP      string `json:"otp"` // TOTP or recovery code, required once TOTP enrolled
+}
+
+// String redacts the password and OTP, so does LogValue, in case of any log on it.
+func (li LoginInfo) String() string {
+	return fmt.Sprintf("{%s *** ***}", li.Username)
+}
+
+func (li LoginInfo) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("username", li.Username),
+		slog.String("password", "***"),
+		slog.String("otp", "***"),
+	)
+}
+
+type InputInfo struct {
+	Lines []string `json:"lines"`
 }
 
 func (s *Service) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	s.web.ServeHTTP(writer, request)
+	request = request.WithContext(attachCerts(request.Context(), s.certIdentities(request)))
+	s.serveAudited(writer, request, s.web)
 }
 
-func (s *Service) Login(_ context.Context, li *LoginInfo) (t *auth.Token, e *CodedError) {
+// Login returns the token, or with query parameter cookie=true sets it in cookies for browsers, see SessionCookie.
+func (s *Service) Login(ctx context.Context, li *LoginInfo) (t *auth.Token, e *CodedError) {
+	entry := detachAudit(ctx)
+	var source string
+	if entry != nil {
+		entry.action, entry.target, entry.user = "Login", li.Username, li.Username
+		source = entry.source
+	}
+	now := time.Now()
+	// Check before bcrypt, which is what the brute force costs.
+	if e := s.acquireLogin(ctx, li.Username, source, now); e != nil {
+		return nil, e
+	}
 	ok, err := s.authClient.Auth(li.Username, li.Password)
 	if err != nil {
+		s.releaseLogin(li.Username, source)
 		return nil, NewCodedError(http.StatusInternalServerError, err)
 	}
 	if !ok {
-		// the incorrect credential is less sensitive, so just carry it and make it printable in error.
-		return nil, NewCodedErrorf(http.StatusForbidden, "no password[%s] on username[%s]", li.Password, li.Username)
+		s.failLogin(li.Username, source, now)
+		s.publish(application.Event{Type: EventLoginFailure, User: li.Username})
+		// Never carry the password, which could be a typo of the right one, or the one of another account.
+		return nil, NewCodedErrorf(http.StatusForbidden, "bad credential on username[%s]", li.Username)
+	}
+	// It fails or releases the attempt on its own.
+	if e := s.verifyOTP(li, source, now); e != nil {
+		return nil, e
+	}
+	s.succeedLogin(li.Username, source)
+	token, err := s.authClient.CreateToken(li.Username, source)
+	if err != nil {
+		return nil, NewCodedError(http.StatusInternalServerError, err)
+	}
+	if entry != nil {
+		entry.tokenID = token.ID
+	}
+	s.publish(application.Event{Type: EventLogin, User: li.Username})
+	if cookie, _ := strconv.ParseBool(DetachQuery(ctx).Get("cookie")); cookie {
+		if err := setSessionCookies(ctx, token); err != nil {
+			return nil, NewCodedError(http.StatusInternalServerError, err)
+		}
+		// Keep it in the HttpOnly cookie only, away from scripts.
+		token.ID = ""
 	}
-	token := s.authClient.CreateToken(li.Username)
 	return &token, nil
 }
 
-// authenticate auth by ctx and return an 403 *CodedError if fails.
-// If logHeaderNullable not empty string, a log with the header name and username would happen.
-func (s *Service) authenticate(ctx context.Context, logHeaderNullable string) *CodedError {
+// verifyOTP returns an 403 *CodedError if the account has enrolled TOTP but the OTP is absent or bad.
+func (s *Service) verifyOTP(li *LoginInfo, source string, now time.Time) *CodedError {
+	account, _ := s.authClient.FindAccount(li.Username)
+	if account.TOTPSecret == "" {
+		return nil
+	}
+	if li.OTP == "" {
+		s.releaseLogin(li.Username, source)
+		return NewCodedErrorf(http.StatusForbidden, "otp required on username[%s]", li.Username)
+	}
+	ok, err := s.authClient.VerifyOTP(li.Username, li.OTP)
+	if err != nil {
+		s.releaseLogin(li.Username, source)
+		return NewCodedError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		s.failLogin(li.Username, source, now)
+		s.publish(application.Event{Type: EventLoginFailure, Message: "bad otp", User: li.Username})
+		return NewCodedErrorf(http.StatusForbidden, "bad otp on username[%s]", li.Username)
+	}
+	return nil
+}
+
+// authenticate auth by ctx and return the username, or an 403 *CodedError if fails,
+// either on the token or on the permission of the account to do the action on target.
+// The action on target is audited whatever the result, and logged if it's not a GET.
+func (s *Service) authenticate(ctx context.Context, action string, target string) (username string, e *CodedError) {
 	tokenID := DetachToken(ctx)
+	entry := detachAudit(ctx)
+	if entry != nil {
+		entry.action, entry.target, entry.tokenID = action, target, tokenID
+	}
+	if strings.HasPrefix(tokenID, auth.APIKeyPrefix) {
+		return s.authenticateKey(entry, tokenID, action, target)
+	}
+	// A token, if any, goes before the certificate, so that a person could log in on a machine with one.
+	if certs := detachCerts(ctx); tokenID == "" && len(certs) > 0 {
+		return s.authenticateCert(entry, certs, action, target)
+	}
+	if tokenID == "" && entry != nil && entry.peer != nil && !entry.peer.gateway() && s.peerAuth {
+		return s.authenticatePeer(entry, *entry.peer, action, target)
+	}
 	t, ok := s.authClient.FindValidToken(tokenID)
 	if !ok {
-		return NewCodedErrorf(http.StatusForbidden, "invalid token on id %v", tokenID)
+		return "", NewCodedErrorf(http.StatusForbidden, "invalid token on id %v", tokenID)
+	}
+	if entry != nil {
+		entry.user = t.Username
+	}
+	account, ok := s.authClient.FindAccount(t.Username)
+	if !ok || account.Disabled() {
+		return "", NewCodedErrorf(http.StatusForbidden, "no account %s", t.Username)
+	}
+	if e := authorize(account, action, target); e != nil {
+		return "", e
 	}
 
-	if logHeaderNullable != "" {
-		slog.Info(logHeaderNullable, "user", t.Username)
+	if entry != nil {
+		if entry.method != http.MethodGet {
+			slog.Info(action, "target", target, "user", t.Username)
+		}
 	}
-	return nil
+	return t.Username, nil
+}
+
+func (s *Service) authenticateKey(entry *auditEntry, key string, action string, target string) (string, *CodedError) {
+	k, ok := s.keyStore.FindValid(key)
+	if !ok {
+		return "", NewCodedErrorf(http.StatusForbidden, "invalid key")
+	}
+	username := keyUserPrefix + k.Name
+	if entry != nil {
+		entry.user = username
+	}
+	if e := authorizeKey(k, action, target); e != nil {
+		return "", e
+	}
+	if entry != nil && entry.method != http.MethodGet {
+		slog.Info(action, "target", target, "user", username)
+	}
+	return username, nil
 }
 
 func (s *Service) GetProcesses(ctx context.Context) ([]monitor.Process, *CodedError) {
-	if err := s.authenticate(ctx, ""); err != nil {
+	if _, err := s.authenticate(ctx, "GetProcesses", ""); err != nil {
 		return nil, err
 	}
 
@@ -153,30 +460,63 @@ func (s *Service) GetProcesses(ctx context.Context) ([]monitor.Process, *CodedEr
 }
 
 func (s *Service) DeleteProcess(ctx context.Context, pid int) *CodedError {
-	if err := s.authenticate(ctx, "DeleteProcess"); err != nil {
+	username, err := s.authenticate(ctx, "DeleteProcess", strconv.Itoa(pid))
+	if err != nil {
 		return err
 	}
 
-	found, err := s.monitorClient.Kill(pid)
-	if err != nil {
-		return NewCodedError(http.StatusInternalServerError, err)
+	found, e := s.monitorClient.Kill(pid)
+	if e != nil {
+		return NewCodedError(http.StatusInternalServerError, e)
 	}
 	if !found {
 		return NewCodedErrorf(http.StatusNotFound, "no process on pid %d", pid)
 	}
+	s.publish(application.Event{Type: EventKill, Message: "pid " + strconv.Itoa(pid), User: username})
 	return nil
 }
 
 func (s *Service) GetApplications(ctx context.Context) ([]ApplicationComplex, *CodedError) {
-	if err := s.authenticate(ctx, ""); err != nil {
-		return nil, err
+	username, e := s.authenticate(ctx, "GetApplications", "")
+	if e != nil {
+		return nil, e
 	}
 	applications := s.applicationRepository.FindAll()
+	if permitted := s.permittedApps(username); permitted != nil {
+		var filtered []application.Application
+		for _, app := range applications {
+			if slices.Contains(permitted, app.ID) {
+				filtered = append(filtered, app)
+			}
+		}
+		applications = filtered
+	}
 	processes, err := s.monitorClient.Scan()
 	if err != nil {
 		return nil, NewCodedError(http.StatusInternalServerError, err)
 	}
-	return CombineTheoryAndReality(applications, processes), nil
+	ret := CombineTheoryAndReality(applications, processes)
+	s.fillStatus(ret)
+	return ret, nil
+}
+
+func (s *Service) findClient(appID int) (*application.Client, bool) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	client, ok := s.appIDToClients[appID]
+	return client, ok
+}
+
+// fillStatus sets Status of those running ones started by us, others are unknown and left empty.
+func (s *Service) fillStatus(apps []ApplicationComplex) {
+	for i := range apps {
+		if len(apps[i].Instances) == 0 {
+			continue
+		}
+		if client, ok := s.findClient(apps[i].ID); ok {
+			apps[i].Status = client.Status()
+		}
+	}
 }
 
 func (s *Service) findApplicationComplex(appID int) (ApplicationComplex, *CodedError) {
@@ -190,59 +530,209 @@ func (s *Service) findApplicationComplex(appID int) (ApplicationComplex, *CodedE
 		return ApplicationComplex{}, NewCodedError(http.StatusInternalServerError, err)
 	}
 
-	return CombineTheoryAndReality([]application.Application{app}, processes)[0], nil
+	ret := CombineTheoryAndReality([]application.Application{app}, processes)
+	s.fillStatus(ret)
+	return ret[0], nil
 }
 
+// StartApplication starts the app, and returns it once started.
+// With query parameter wait=true, it returns until the app is no longer application.StatusStarting.
+// readyWaitMargin is how long StartApplication waits more than ReadyTimeout of the app, for tee to tell.
+const readyWaitMargin = 5 * time.Second
+
 func (s *Service) StartApplication(ctx context.Context, appID int) (ApplicationComplex, *CodedError) {
-	if err := s.authenticate(ctx, "StartApplication "+strconv.Itoa(appID)); err != nil {
+	if _, err := s.authenticate(ctx, "StartApplication", strconv.Itoa(appID)); err != nil {
+		return ApplicationComplex{}, err
+	}
+
+	client, err := s.startApplication(appID)
+	if err != nil {
+		return ApplicationComplex{}, err
+	}
+
+	if wait, _ := strconv.ParseBool(DetachQuery(ctx).Get("wait")); wait {
+		// The deadline is of the app rather than the handler, as ReadyTimeout differs between apps.
+		// The app is always ready by then, unless it's restarted or stopped in the middle.
+		waitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), client.ReadyTimeout()+readyWaitMargin)
+		defer cancel()
+		if e := client.WaitReady(waitCtx); e != nil {
+			return ApplicationComplex{}, NewCodedErrorf(http.StatusServiceUnavailable, "app %d not ready: %v", appID, e)
+		}
+	}
+
+	app, err := s.findApplicationComplex(appID)
+	if err != nil {
 		return ApplicationComplex{}, err
 	}
+	return app, nil
+}
 
+func (s *Service) startApplication(appID int) (*application.Client, *CodedError) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	// Just prevent concurrent StartApplication,
 	// It's user's duty to keep external exec away to achieve atomicity.
 	app, err := s.findApplicationComplex(appID)
 	if err != nil {
-		return ApplicationComplex{}, err
+		return nil, err
 	}
 
 	if len(app.Instances) > 0 {
-		return ApplicationComplex{}, NewCodedErrorf(http.StatusConflict, "running duplicates %d", len(app.Instances))
+		return nil, NewCodedErrorf(http.StatusConflict, "running duplicates %d", len(app.Instances))
 	}
 
 	// I think 1k line is long enough.
-	client, e := application.NewClient(app.Application, 1000)
+	client, e := application.NewClient(app.Application, 1000, s.onEvent)
 	if e != nil {
-		return ApplicationComplex{}, NewCodedError(http.StatusServiceUnavailable, e)
+		return nil, NewCodedError(http.StatusServiceUnavailable, e)
 	}
+	s.clientsMu.Lock()
 	s.appIDToClients[appID] = client
+	s.clientsMu.Unlock()
+	return client, nil
+}
+
+// onEvent is the application.Listener of every application.Client.
+func (s *Service) onEvent(e application.Event) {
+	s.publish(e)
+	if e.Type == application.EventAlert && e.Alert.Action == application.AlertActionWebhook {
+		s.notifierClient.NotifyWebhook(e.Alert.Webhook, e)
+	}
+	if e.Type == application.EventAlert && e.Alert.Action == application.AlertActionRestart {
+		// Never block the listener, and restart waits for the old one to be gone.
+		go func() {
+			if err := s.restartApplication(e.AppID); err != nil {
+				slog.Error("restart on alert", "appID", e.AppID, "rule", e.Alert.Rule, "err", err)
+			}
+		}()
+	}
+}
 
-	app, err = s.findApplicationComplex(appID)
+// restartApplication kills running instances of the app, and starts it again once they are gone.
+func (s *Service) restartApplication(appID int) *CodedError {
+	app, err := s.findApplicationComplex(appID)
 	if err != nil {
-		return ApplicationComplex{}, err
+		return err
 	}
-	return app, nil
+	for _, node := range app.Instances {
+		if _, e := s.monitorClient.Kill(node.Process.PID); e != nil {
+			return NewCodedError(http.StatusInternalServerError, e)
+		}
+	}
+	for i := 0; len(app.Instances) > 0; i++ {
+		if i >= 100 {
+			return NewCodedErrorf(http.StatusServiceUnavailable, "app %d still running after kill", appID)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if app, err = s.findApplicationComplex(appID); err != nil {
+			return err
+		}
+	}
+	if old, ok := s.findClient(appID); ok {
+		old.Terminate()
+	}
+	if _, err = s.startApplication(appID); err != nil {
+		return err
+	}
+	s.publish(application.Event{
+		AppID:   appID,
+		Type:    application.EventRestart,
+		Message: "restart on alert",
+	})
+	return nil
 }
 
 func (s *Service) ReloadAppConfig(ctx context.Context) (*application.ReloadResult, *CodedError) {
-	if err := s.authenticate(ctx, ""); err != nil {
-		return nil, err
+	username, e := s.authenticate(ctx, "ReloadAppConfig", "")
+	if e != nil {
+		return nil, e
 	}
 	ret, err := s.applicationRepository.Reload()
 	if err != nil {
 		return nil, NewCodedError(http.StatusServiceUnavailable, err)
 	}
+	s.publish(application.Event{
+		Type:    EventConfigReload,
+		Message: fmt.Sprintf("%d apps", ret.After.ItemCount),
+		User:    username,
+	})
 	return ret, nil
 }
 
-func (s *Service) GetApplicationOutput(ctx context.Context, appID int) ([]string, *CodedError) {
-	if err := s.authenticate(ctx, ""); err != nil {
+// GetApplicationOutput returns output lines of the app.
+// Query parameters level (comma separated) and field (repeatable key=value) filter on the parsed Record,
+// and records=true makes it return []application.Record rather than raw lines.
+func (s *Service) GetApplicationOutput(ctx context.Context, appID int) (any, *CodedError) {
+	if _, err := s.authenticate(ctx, "GetApplicationOutput", strconv.Itoa(appID)); err != nil {
 		return nil, err
 	}
-	app, ok := s.appIDToClients[appID]
+	app, ok := s.findClient(appID)
 	if !ok {
 		return nil, NewCodedErrorf(http.StatusNotFound, "app on not exists id %d", appID)
 	}
-	return app.Query(), nil
+
+	query := DetachQuery(ctx)
+	filter := application.RecordFilter{}
+	for _, levels := range query["level"] {
+		filter.Levels = append(filter.Levels, strings.Split(levels, ",")...)
+	}
+	for _, field := range query["field"] {
+		k, v, found := strings.Cut(field, "=")
+		if !found {
+			return nil, NewCodedErrorf(http.StatusBadRequest, "bad field filter %s, want key=value", field)
+		}
+		if filter.Fields == nil {
+			filter.Fields = make(map[string]string)
+		}
+		filter.Fields[k] = v
+	}
+	wantRecords, _ := strconv.ParseBool(query.Get("records"))
+
+	records := app.QueryRecords()
+	matched := make([]application.Record, 0, len(records))
+	for _, r := range records {
+		if filter.Match(r) {
+			matched = append(matched, r)
+		}
+	}
+	if wantRecords {
+		return matched, nil
+	}
+	lines := make([]string, len(matched))
+	for i, r := range matched {
+		lines[i] = r.Line
+	}
+	return lines, nil
+}
+
+func (s *Service) GetApplicationEvents(ctx context.Context, appID int) ([]application.Event, *CodedError) {
+	if _, err := s.authenticate(ctx, "GetApplicationEvents", strconv.Itoa(appID)); err != nil {
+		return nil, err
+	}
+	app, ok := s.findClient(appID)
+	if !ok {
+		return nil, NewCodedErrorf(http.StatusNotFound, "app on not exists id %d", appID)
+	}
+	return app.Events(), nil
+}
+
+func (s *Service) InputApplication(ctx context.Context, appID int, info *InputInfo) *CodedError {
+	username, e := s.authenticate(ctx, "InputApplication", strconv.Itoa(appID))
+	if e != nil {
+		return e
+	}
+	app, ok := s.findClient(appID)
+	if !ok {
+		return NewCodedErrorf(http.StatusNotFound, "app on not exists id %d", appID)
+	}
+	if err := app.Input(username, info.Lines); err != nil {
+		if errors.Is(err, application.ErrMultilineInput) {
+			return NewCodedError(http.StatusBadRequest, err)
+		}
+		if errors.Is(err, application.ErrNoStdin) || errors.Is(err, application.ErrTerminated) {
+			return NewCodedError(http.StatusConflict, err)
+		}
+		return NewCodedError(http.StatusServiceUnavailable, err)
+	}
+	return nil
 }